@@ -1,8 +1,19 @@
 package main
 
 import (
+	"encoding/base64"
+	"github.com/garyburd/redigo/redis"
 	"github.com/larzconwell/httpextra"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits for the number of activities given at once.
+const (
+	activitiesLimitDefault = 50
+	activitiesLimitMax     = 500
 )
 
 func init() {
@@ -11,6 +22,101 @@ func init() {
 	Routes = append(Routes, getActivities)
 }
 
+// TrimActivitiesJob enforces the activity retention for users who haven't had new
+// activities to trim them, a batch of users is swept on each tick.
+func TrimActivitiesJob() Job {
+	cursor := "0"
+
+	return func(conn *Conn) error {
+		reply, err := redis.Values(conn.Do("sscan", ActivityUsersKey, cursor, "count", activityTrimBatch))
+		if err != nil {
+			return err
+		}
+
+		var users []string
+		_, err = redis.Scan(reply, &cursor, &users)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			_, err = conn.TrimActivities(user, activityTrimBatch)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// ActivityCursor represents the position after the last activity in a page. Skip is the
+// number of activities with the same score that were already given.
+type ActivityCursor struct {
+	Score int64
+	Skip  int
+}
+
+// ParseActivityCursor parses an encoded cursor.
+func ParseActivityCursor(cursor string) (*ActivityCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrActivityCursorInvalid
+	}
+
+	parts := strings.Split(string(decoded), ":")
+	if len(parts) != 2 {
+		return nil, ErrActivityCursorInvalid
+	}
+
+	score, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrActivityCursorInvalid
+	}
+
+	skip, err := strconv.Atoi(parts[1])
+	if err != nil || skip < 0 {
+		return nil, ErrActivityCursorInvalid
+	}
+
+	return &ActivityCursor{score, skip}, nil
+}
+
+// NextActivityCursor gets the cursor for the page after the given activities.
+func NextActivityCursor(activities []*Activity, cursor *ActivityCursor) *ActivityCursor {
+	last := activities[len(activities)-1].score
+	next := &ActivityCursor{Score: last}
+
+	for _, activity := range activities {
+		if activity.score == last {
+			next.Skip++
+		}
+	}
+
+	// The page may have only had activities with the same score as the last page
+	if cursor != nil && cursor.Score == last {
+		next.Skip += cursor.Skip
+	}
+
+	return next
+}
+
+// String encodes the cursor.
+func (cursor *ActivityCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(cursor.Score, 10) + ":" +
+		strconv.Itoa(cursor.Skip)))
+}
+
+// activityTime parses a query time as an activity score.
+func activityTime(value string) (string, error) {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", ErrActivityTimeInvalid
+	}
+
+	return strconv.FormatInt(ActivityScore(date), 10), nil
+}
+
 func GetActivitiesHandler(rw http.ResponseWriter, req *http.Request) {
 	conn := Pool.Get()
 	defer conn.Close()
@@ -20,12 +126,97 @@ func GetActivitiesHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	res := &httpextra.Response{ContentTypes, rw, req}
+	query := req.URL.Query()
+
+	var (
+		cursor *ActivityCursor
+		errs   []string
+		err    error
+	)
+	min := "-inf"
+	max := "+inf"
+	offset := 0
+	limit := activitiesLimitDefault
+
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > activitiesLimitMax {
+			errs = append(errs, ErrActivityLimitInvalid.Error())
+		}
+	}
+
+	// Before and after are exclusive, since includes activities at the time
+	if value := query.Get("before"); value != "" {
+		max, err = activityTime(value)
+		max = "(" + max
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if value := query.Get("after"); value != "" {
+		min, err = activityTime(value)
+		min = "(" + min
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if value := query.Get("since"); value != "" {
+		if query.Get("after") != "" {
+			errs = append(errs, ErrActivitySinceAfter.Error())
+		}
 
-	activities, err := conn.GetActivities(user.Name)
+		min, err = activityTime(value)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// The cursor continues from the last page, within the same time range
+	if value := query.Get("cursor"); value != "" {
+		cursor, err = ParseActivityCursor(value)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			max = strconv.FormatInt(cursor.Score, 10)
+			offset = cursor.Skip
+		}
+	}
+
+	ok := HandleValidations(rw, req, errs, nil)
+	if !ok {
+		return
+	}
+
+	// Exports give every activity in the range instead of a page
+	if mediaType := responseType(req); mediaType == CSVType || mediaType == NDJSONType {
+		rw.Header().Set("Content-Type", mediaType)
+		rw.Header().Set("Content-Disposition", "attachment; filename=\"activities"+
+			ContentTypes[mediaType].Extension+"\"")
+		rw.WriteHeader(http.StatusOK)
+
+		err := exportActivities(conn, rw, user, mediaType, query.Get("type"), min, max, cursor)
+		if err != nil {
+			// The status was already sent, so the connection is aborted for clients to see the
+			// export is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	// Get an extra activity to know if there's another page
+	activities, err := conn.GetActivitiesRange(user.Name, query.Get("type"), min, max, offset,
+		limit+1)
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
+	if len(activities) > limit {
+		activities = activities[:limit]
+		query.Set("cursor", NextActivityCursor(activities, cursor).String())
+
+		rw.Header().Set("Link", "<"+req.URL.Path+"?"+query.Encode()+">; rel=\"next\"")
+	}
+
 	res.Send(activities, http.StatusOK)
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestActivityCursor(t *testing.T) {
+	cursor := &ActivityCursor{1791000000000000, 2}
+
+	parsed, err := ParseActivityCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *cursor {
+		t.Error("cursor did not round trip, got", *parsed)
+	}
+
+	_, err = ParseActivityCursor("bm90IGEgY3Vyc29y")
+	if err != ErrActivityCursorInvalid {
+		t.Error("invalid cursor was parsed")
+	}
+}
+
+func TestNextActivityCursor(t *testing.T) {
+	activities := []*Activity{{score: 30}, {score: 20}, {score: 20}}
+
+	next := NextActivityCursor(activities, nil)
+	if next.Score != 20 || next.Skip != 2 {
+		t.Error("expected to skip 2 activities at 20, got", *next)
+	}
+
+	// A page of ties continues skipping from the last cursor
+	next = NextActivityCursor([]*Activity{{score: 20}, {score: 20}}, next)
+	if next.Score != 20 || next.Skip != 4 {
+		t.Error("expected to skip 4 activities at 20, got", *next)
+	}
+}
+
+func TestActivityIndexKeys(t *testing.T) {
+	activity := &Activity{Type: ActivityDeviceCreated, User: &User{Name: "larz"}}
+
+	keys := activity.indexKeys()
+	expected := []string{"users:larz:activities:index", "users:larz:activities:types:device.created",
+		"users:larz:activities:types:device"}
+	if len(keys) != len(expected) {
+		t.Fatal("expected", expected, "got", keys)
+	}
+
+	for i, key := range keys {
+		if key != expected[i] {
+			t.Error("expected", expected[i], "got", key)
+		}
+	}
+}
+
+func TestUserMutes(t *testing.T) {
+	user := &User{Muted: []string{"task", ActivityDeviceCreated}}
+
+	if !user.Mutes(ActivityTaskCreated) {
+		t.Error("expected category task to be muted")
+	}
+	if !user.Mutes(ActivityDeviceCreated) {
+		t.Error("expected device.created to be muted")
+	}
+	if user.Mutes(ActivityDeviceDeleted) {
+		t.Error("expected device.deleted not to be muted")
+	}
+
+	if !ValidActivityType("list") || ValidActivityType("task.unknown") {
+		t.Error("expected only known types and categories to be valid")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"code.google.com/p/go.crypto/bcrypt"
 	"encoding/base64"
 	"github.com/larzconwell/httpextra"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -23,6 +24,15 @@ func MatchPass(plainPass, hashPass string) (bool, error) {
 	return match, err
 }
 
+// queryToken uses the token query item as a token Authorization header if none was given,
+// for clients like EventSource and WebSocket that can't set headers.
+func queryToken(req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+}
+
 // Authenticate authenticates the request handling responses for required auth.
 func Authenticate(conn *Conn, rw http.ResponseWriter, req *http.Request) *User {
 	authorization := req.Header.Get("Authorization")
@@ -74,7 +84,7 @@ func Authenticate(conn *Conn, rw http.ResponseWriter, req *http.Request) *User {
 
 	if authType == "basic" {
 		authValue = strings.SplitN(authValue, " ", 2)[0]
-		user, err := basicAuthenticate(conn, authValue)
+		user, err := basicAuthenticate(conn, authValue, req.RemoteAddr)
 		if err != nil {
 			status := http.StatusInternalServerError
 			if err == ErrNoAuthPassword {
@@ -99,8 +109,9 @@ func tokenAuthenticate(conn *Conn, token string) (*User, error) {
 	return conn.GetUserByToken(token)
 }
 
-// basicAuthenticate authenticates according to rfc 2617.
-func basicAuthenticate(conn *Conn, userpass string) (*User, error) {
+// basicAuthenticate authenticates according to rfc 2617, failed attempts are recorded
+// with the address they came from.
+func basicAuthenticate(conn *Conn, userpass, addr string) (*User, error) {
 	data, err := base64.StdEncoding.DecodeString(userpass)
 	if err != nil {
 		return nil, err
@@ -126,7 +137,13 @@ func basicAuthenticate(conn *Conn, userpass string) (*User, error) {
 		if matches {
 			return user, nil
 		} else {
-			activity := &Activity{Conn: conn, Message: "Invalid login attempt", User: user}
+			ip, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				ip = addr
+			}
+
+			activity := &Activity{Conn: conn, Type: ActivityAuthFailed, Message: "Invalid login attempt",
+				Meta: map[string]string{"ip": ip}, User: user}
 			err = activity.Save()
 			if err != nil {
 				return nil, err
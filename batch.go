@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/larzconwell/httpextra"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// batchMax is the most operations a batch can contain.
+const batchMax = 100
+
+// batchBodyMax is the most bytes in a batch, enough for every operation to have the largest notes.
+const batchBodyMax = batchMax * 128 * 1024
+
+func init() {
+	batchTasks := &Route{"BatchTasks", "/tasks/batch", []string{"POST"}, BatchTasksHandler}
+
+	Routes = append(Routes, batchTasks)
+}
+
+// BatchOperation represents a single operation on a task, fields that
+// aren't given are left unchanged.
+type BatchOperation struct {
+	Op       string  `json:"op"`
+	ID       int     `json:"id"`
+	Message  *string `json:"message"`
+	Notes    *string `json:"notes"`
+	Category *string `json:"category"`
+	Complete *bool   `json:"complete"`
+	Priority *string `json:"priority"`
+	Remind   *string `json:"remind"`
+	Tags     *string `json:"tags"`
+	List     *string `json:"list"`
+	Assignee *string `json:"assignee"`
+}
+
+// BatchResult represents the outcome of a single operation.
+type BatchResult struct {
+	Status int      `json:"status"`
+	Task   *Task    `json:"task,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// prepare gets the task for the operation and applies the changes, validating the result.
+// Existing tasks are watched so the batch fails if they change before it's applied.
+func (op *BatchOperation) prepare(conn *Conn, user *User) (*Task, *BatchResult, error) {
+	var task *Task
+
+	switch op.Op {
+	case "create":
+		task = &Task{Conn: conn, User: user}
+	case "update", "complete", "delete":
+		err := conn.WatchTask(user.Name, strconv.Itoa(op.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		task, err = conn.GetTask(user.Name, strconv.Itoa(op.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if task == nil {
+			return nil, &BatchResult{Status: http.StatusNotFound,
+				Error: http.StatusText(http.StatusNotFound)}, nil
+		}
+		task.User = user
+	default:
+		return nil, &BatchResult{Status: http.StatusBadRequest,
+			Errors: []string{ErrBatchOpInvalid.Error()}}, nil
+	}
+
+	if op.Op == "delete" {
+		return task, &BatchResult{Status: http.StatusOK, Task: task}, nil
+	}
+
+	if op.Message != nil {
+		task.Message = *op.Message
+	}
+	if op.Notes != nil {
+		task.Notes = *op.Notes
+	}
+	if op.Category != nil {
+		task.Category = *op.Category
+	}
+	if op.Complete != nil {
+		task.Complete = *op.Complete
+	}
+	if op.Op == "complete" {
+		task.Complete = true
+	}
+	if op.Priority != nil {
+		task.Priority = strings.ToUpper(*op.Priority)
+	}
+	if op.Remind != nil {
+		task.Remind = *op.Remind
+	}
+	if op.Tags != nil {
+		task.Tags = ParseTags(*op.Tags)
+	}
+	if op.List != nil {
+		task.List = *op.List
+	}
+	if op.Assignee != nil {
+		task.Assignee = *op.Assignee
+	}
+
+	errs, err := task.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	if errs != nil {
+		return nil, &BatchResult{Status: http.StatusBadRequest, Errors: errs}, nil
+	}
+
+	return task, &BatchResult{Status: http.StatusOK, Task: task}, nil
+}
+
+func BatchTasksHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	var body struct {
+		Operations []*BatchOperation `json:"operations"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, batchBodyMax)).Decode(&body)
+	if bodyTooLarge(err) {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusRequestEntityTooLarge)},
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Operations) <= 0 {
+		HandleValidations(rw, req, []string{ErrBatchEmpty.Error()}, nil)
+		return
+	}
+	if len(body.Operations) > batchMax {
+		HandleValidations(rw, req, []string{ErrBatchTooLarge.Error()}, nil)
+		return
+	}
+
+	// Prepare every operation first, nothing is applied unless they're all valid
+	tasks := make([]*Task, len(body.Operations))
+	results := make([]*BatchResult, len(body.Operations))
+	seen := make(map[int]bool)
+	valid := true
+	for i, op := range body.Operations {
+		if op.Op != "create" && seen[op.ID] {
+			results[i] = &BatchResult{Status: http.StatusBadRequest,
+				Errors: []string{ErrBatchTaskDuplicate.Error()}}
+			valid = false
+			continue
+		}
+		seen[op.ID] = true
+
+		tasks[i], results[i], err = op.prepare(conn, user)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+
+		if results[i].Status != http.StatusOK {
+			valid = false
+		}
+	}
+
+	if !valid {
+		res.Send(map[string]interface{}{"results": results}, http.StatusBadRequest)
+		return
+	}
+
+	// Ids can't be generated inside the transaction since replies are queued
+	for i, op := range body.Operations {
+		if op.Op == "create" {
+			tasks[i].ID, err = conn.NextTaskID(user.Name)
+			if err != nil {
+				res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	err = conn.Transaction(func() error {
+		for i, op := range body.Operations {
+			var err error
+			if op.Op == "delete" {
+				err = tasks[i].Trash()
+			} else {
+				err = tasks[i].Save(false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err == ErrTransactionAborted {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusConflict)}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(map[string]interface{}{"results": results}, http.StatusOK)
+}
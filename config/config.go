@@ -14,19 +14,48 @@ type TLS struct {
 	Key  string `json:"key"`
 }
 
+// SMTP describes the mail server used to send email notifications.
+type SMTP struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
 // Config describes generic options for a server.
 type Config struct {
-	LogDir              string        `json:"logdir"`
-	DBAddr              string        `json:"dbaddr"`
-	DBNetwork           string        `json:"dbnetwork"`
-	DBMaxIdle           int           `json:"dbmaxidle"`
-	DBMaxTimeoutStr     string        `json:"dbmaxtimeout"`
-	DBMaxTimeout        time.Duration `json:"-"`
-	ServerAddr          string        `json:"serveraddr"`
-	ServerNetwork       string        `json:"servernetwork"`
-	ServerMaxTimeoutStr string        `json:"servermaxtimeout"`
-	ServerMaxTimeout    time.Duration `json:"-"`
-	TLS                 *TLS          `json:"tls"`
+	LogDir               string        `json:"logdir"`
+	DBAddr               string        `json:"dbaddr"`
+	DBNetwork            string        `json:"dbnetwork"`
+	DBMaxIdle            int           `json:"dbmaxidle"`
+	DBMaxTimeoutStr      string        `json:"dbmaxtimeout"`
+	DBMaxTimeout         time.Duration `json:"-"`
+	ServerAddr           string        `json:"serveraddr"`
+	ServerNetwork        string        `json:"servernetwork"`
+	ServerMaxTimeoutStr  string        `json:"servermaxtimeout"`
+	ServerMaxTimeout     time.Duration `json:"-"`
+	TLS                  *TLS          `json:"tls"`
+	SchedulerTickStr     string        `json:"schedulertick"`
+	SchedulerTick        time.Duration `json:"-"`
+	ReminderLeaseStr     string        `json:"reminderlease"`
+	ReminderLease        time.Duration `json:"-"`
+	Notifiers            []string      `json:"notifiers"`
+	WebhookURL           string        `json:"webhookurl"`
+	SMTP                 *SMTP         `json:"smtp"`
+	HistoryMax           int           `json:"historymax"`
+	TrashRetentionStr    string        `json:"trashretention"`
+	TrashRetention       time.Duration `json:"-"`
+	ActivityMax          int           `json:"activitymax"`
+	ActivityRetentionStr string        `json:"activityretention"`
+	ActivityRetention    time.Duration `json:"-"`
+	EventMax             int           `json:"eventmax"`
+	EventHeartbeatStr    string        `json:"eventheartbeat"`
+	EventHeartbeat       time.Duration `json:"-"`
+	WebhookAttempts      int           `json:"webhookattempts"`
+	WebhookBackoffStr    string        `json:"webhookbackoff"`
+	WebhookBackoff       time.Duration `json:"-"`
+	WebhookFailureMax    int           `json:"webhookfailuremax"`
+	WebhookAllowPrivate  bool          `json:"webhookallowprivate"`
 }
 
 // ReadFiles reads the given JSON config files and returns the combined config.
@@ -45,11 +74,31 @@ func ReadFiles(files ...string) (*Config, error) {
 		decoder.Decode(config)
 	}
 
-	if config.DBMaxTimeoutStr != "" {
-		config.DBMaxTimeout, err = time.ParseDuration(config.DBMaxTimeoutStr)
+	// Durations are given as strings, the first that can't be parsed is returned
+	durations := []struct {
+		value    string
+		duration *time.Duration
+	}{
+		{config.DBMaxTimeoutStr, &config.DBMaxTimeout},
+		{config.ServerMaxTimeoutStr, &config.ServerMaxTimeout},
+		{config.SchedulerTickStr, &config.SchedulerTick},
+		{config.ReminderLeaseStr, &config.ReminderLease},
+		{config.TrashRetentionStr, &config.TrashRetention},
+		{config.ActivityRetentionStr, &config.ActivityRetention},
+		{config.EventHeartbeatStr, &config.EventHeartbeat},
+		{config.WebhookBackoffStr, &config.WebhookBackoff},
 	}
-	if config.ServerMaxTimeoutStr != "" {
-		config.ServerMaxTimeout, err = time.ParseDuration(config.ServerMaxTimeoutStr)
+
+	for _, item := range durations {
+		if item.value == "" {
+			continue
+		}
+
+		*item.duration, err = time.ParseDuration(item.value)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return config, err
+
+	return config, nil
 }
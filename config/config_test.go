@@ -1,7 +1,10 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestRead(t *testing.T) {
@@ -20,3 +23,54 @@ func TestRead(t *testing.T) {
 		t.Error("DBNetwork option is incorrect")
 	}
 }
+
+func TestReadDurations(t *testing.T) {
+	config, err := ReadFiles("environment.json", "development.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.SchedulerTick != 10*time.Second {
+		t.Error("SchedulerTick option is incorrect")
+	}
+
+	if config.ReminderLease != time.Minute {
+		t.Error("ReminderLease option is incorrect")
+	}
+
+	if config.TrashRetention != 30*24*time.Hour {
+		t.Error("TrashRetention option is incorrect")
+	}
+
+	if config.ActivityRetention != 90*24*time.Hour {
+		t.Error("ActivityRetention option is incorrect")
+	}
+
+	if config.EventHeartbeat != 15*time.Second {
+		t.Error("EventHeartbeat option is incorrect")
+	}
+
+	if config.WebhookBackoff != 30*time.Second {
+		t.Error("WebhookBackoff option is incorrect")
+	}
+}
+
+func TestReadDurationsInvalid(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	// A later valid duration shouldn't hide an earlier invalid one
+	_, err = file.WriteString(`{"schedulertick": "soon", "webhookbackoff": "30s"}`)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadFiles(file.Name())
+	if err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
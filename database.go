@@ -2,8 +2,13 @@ package main
 
 import (
 	"code.google.com/p/go.crypto/bcrypt"
+	"encoding/json"
 	"github.com/garyburd/redigo/redis"
 	"github.com/nu7hatch/gouuid"
+	"math"
+	"net/mail"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -11,17 +16,255 @@ import (
 
 // Database keys.
 var (
-	UserKey       = "users:{{user}}"
-	DevicesKey    = "users:{{user}}:devices"
-	DeviceKey     = "users:{{user}}:devices:{{device}}"
-	ActivitiesKey = "users:{{user}}:activities"
-	ActivityKey   = "users:{{user}}:activities:{{activity}}"
-	TasksKey      = "users:{{user}}:tasks"
-	TasksIDKey    = "users:{{user}}:tasks:id"
-	TaskKey       = "users:{{user}}:tasks:{{task}}"
-	TokenKey      = "tokens:{{token}}"
+	UserKey          = "users:{{user}}"
+	DevicesKey       = "users:{{user}}:devices"
+	DeviceKey        = "users:{{user}}:devices:{{device}}"
+	ActivitiesKey    = "users:{{user}}:activities"
+	ActivityKey      = "users:{{user}}:activities:{{activity}}"
+	ActivityIndexKey = "users:{{user}}:activities:index"
+	ActivityIDKey    = "users:{{user}}:activities:id"
+	ActivityTypeKey  = "users:{{user}}:activities:types:{{type}}"
+	EventsKey        = "users:{{user}}:events"
+	EventIDKey       = "users:{{user}}:events:id"
+	EventsChannel    = "events:{{user}}"
+	ChangesKey       = "users:{{user}}:changes"
+	ChangeIDKey      = "users:{{user}}:changes:id"
+	WebhooksKey      = "users:{{user}}:webhooks"
+	EnabledHooksKey  = "users:{{user}}:webhooks:enabled"
+	WebhookKey       = "users:{{user}}:webhooks:{{webhook}}"
+	DeliveriesKey    = "users:{{user}}:webhooks:{{webhook}}:deliveries"
+	TasksKey         = "users:{{user}}:tasks"
+	TasksIDKey       = "users:{{user}}:tasks:id"
+	TaskKey          = "users:{{user}}:tasks:{{task}}"
+	HistoryKey       = "users:{{user}}:tasks:{{task}}:history"
+	UIDsKey          = "users:{{user}}:tasks:uids"
+	SyncRefsKey      = "users:{{user}}:tasks:syncrefs"
+	TrashKey         = "users:{{user}}:trash"
+	AssignedKey      = "users:{{user}}:assigned"
+	UserListsKey     = "users:{{user}}:lists"
+	ListKey          = "lists:{{list}}"
+	MembersKey       = "lists:{{list}}:members"
+	ListTasksKey     = "lists:{{list}}:tasks"
+	TokenKey         = "tokens:{{token}}"
+	CategoryKey      = "users:{{user}}:categories:{{category}}"
+	TagsKey          = "users:{{user}}:tags"
+	TagKey           = "users:{{user}}:tags:{{tag}}"
+	SearchKey        = "users:{{user}}:search"
+	SearchTermKey    = "users:{{user}}:search:terms:{{term}}"
+	SearchDocKey     = "users:{{user}}:search:docs:{{doc}}"
+	RemindersKey     = "reminders"
+	ClaimedKey       = "reminders:claimed"
+	DeliveredKey     = "reminders:{{reminder}}:delivered"
+	PurgeKey         = "trash"
+	ActivityUsersKey = "activities"
+	WebhookQueueKey  = "webhooks"
+	WebhookClaimKey  = "webhooks:claimed"
 )
 
+// claimReminders atomically moves due reminders to the claimed set, leasing them
+// to the caller until the given time.
+var claimReminders = redis.NewScript(2, `
+local items = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "limit", 0, ARGV[3])
+for _, item in ipairs(items) do
+  redis.call("zrem", KEYS[1], item)
+  redis.call("zadd", KEYS[2], ARGV[2], item)
+end
+return items
+`)
+
+// requeueClaims atomically moves claimed items with expired leases back to the
+// queue, due immediately.
+var requeueClaims = redis.NewScript(2, `
+local items = redis.call("zrangebyscore", KEYS[2], "-inf", ARGV[1])
+for _, item in ipairs(items) do
+  redis.call("zrem", KEYS[2], item)
+  redis.call("zadd", KEYS[1], ARGV[1], item)
+end
+return #items
+`)
+
+// requeueReminders atomically moves claimed reminders back to the reminders set, given
+// as reminder and score pairs. Reminders whose lease has been renewed since are skipped.
+var requeueReminders = redis.NewScript(2, `
+for i = 2, #ARGV, 2 do
+  local lease = redis.call("zscore", KEYS[2], ARGV[i])
+  if lease and tonumber(lease) <= tonumber(ARGV[1]) then
+    redis.call("zrem", KEYS[2], ARGV[i])
+    redis.call("zadd", KEYS[1], ARGV[i + 1], ARGV[i])
+  end
+end
+return 1
+`)
+
+// indexDocument atomically replaces the terms indexed for a document. The keys are the
+// search set, the documents term set, the previous term keys, and then the new term keys,
+// with the number of previous terms given after the document and the new terms given as
+// term and frequency pairs. Giving no new terms removes the document from the index.
+var indexDocument = redis.NewScript(-1, `
+local previous = tonumber(ARGV[2])
+for i = 3, previous + 2 do
+  redis.call("zrem", KEYS[i], ARGV[1])
+end
+redis.call("del", KEYS[2])
+redis.call("srem", KEYS[1], ARGV[1])
+for i = previous + 3, #KEYS do
+  local term = (i - previous - 3) * 2 + 3
+  redis.call("zadd", KEYS[i], ARGV[term + 1], ARGV[1])
+  redis.call("sadd", KEYS[2], ARGV[term])
+end
+if #KEYS > previous + 2 then
+  redis.call("sadd", KEYS[1], ARGV[1])
+end
+return #KEYS - previous - 2
+`)
+
+// untagTask removes a task from a tag, removing the tag from the users tags once no tasks
+// have it.
+var untagTask = redis.NewScript(2, `
+redis.call("srem", KEYS[1], ARGV[1])
+if redis.call("scard", KEYS[1]) == 0 then
+  redis.call("srem", KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// appendTask adds a task to the end of its category order, tasks already in the order
+// keep their position. The position is returned.
+var appendTask = redis.NewScript(1, `
+local position = redis.call("zscore", KEYS[1], ARGV[1])
+if not position then
+  local last = redis.call("zrevrange", KEYS[1], 0, 0, "withscores")
+  position = 1
+  if last[2] then
+    position = tonumber(last[2]) + 1
+  end
+  redis.call("zadd", KEYS[1], position, ARGV[1])
+end
+return tostring(position)
+`)
+
+// moveTask moves a task before or after another in a category order, placing it between
+// its new neighbours. Positions are renumbered only when neighbours get too close. A target
+// that isn't in the order yet is added to the end first. The move is recorded as the change
+// ARGV[4] in the change log KEYS[2] numbered by KEYS[3], like recordChange.
+var moveTask = redis.NewScript(3, `
+redis.call("zrem", KEYS[1], ARGV[1])
+local rank = redis.call("zrank", KEYS[1], ARGV[2])
+if not rank then
+  local last = redis.call("zrevrange", KEYS[1], 0, 0, "withscores")
+  local position = 1
+  if last[2] then
+    position = tonumber(last[2]) + 1
+  end
+  redis.call("zadd", KEYS[1], position, ARGV[2])
+  rank = redis.call("zrank", KEYS[1], ARGV[2])
+end
+if ARGV[3] == "after" then
+  rank = rank + 1
+end
+
+local function neighbours()
+  local before, after
+  if rank > 0 then
+    before = tonumber(redis.call("zrange", KEYS[1], rank - 1, rank - 1, "withscores")[2])
+  end
+  local next = redis.call("zrange", KEYS[1], rank, rank, "withscores")
+  if next[2] then
+    after = tonumber(next[2])
+  end
+  return before, after
+end
+
+local before, after = neighbours()
+if before and after and after - before < 1e-6 then
+  for i, item in ipairs(redis.call("zrange", KEYS[1], 0, -1)) do
+    redis.call("zadd", KEYS[1], i, item)
+  end
+  before, after = neighbours()
+end
+
+local position = 1
+if before and after then
+  position = (before + after) / 2
+elseif before then
+  position = before + 1
+elseif after then
+  position = after - 1
+end
+redis.call("zadd", KEYS[1], position, ARGV[1])
+redis.call("zadd", KEYS[2], redis.call("incr", KEYS[3]), ARGV[4])
+return tostring(position)
+`)
+
+// saveActivity saves the activity ARGV[1] unless the user hash KEYS[1] mutes its type ARGV[3]
+// or category ARGV[4], returning 1 if it was saved. It's added to the list KEYS[2] and its hash
+// KEYS[3] is set to the fields after ARGV[5], the user ARGV[5] is added to the set KEYS[4], and
+// the rest of the keys are indexes it's added to with the score ARGV[2].
+var saveActivity = redis.NewScript(-1, `
+local muted = redis.call("hget", KEYS[1], "muted")
+if muted and muted ~= "" then
+  muted = "," .. muted .. ","
+  if muted:find("," .. ARGV[3] .. ",", 1, true) or muted:find("," .. ARGV[4] .. ",", 1, true) then
+    return 0
+  end
+end
+redis.call("lpush", KEYS[2], ARGV[1])
+redis.call("hmset", KEYS[3], unpack(ARGV, 6))
+redis.call("sadd", KEYS[4], ARGV[5])
+for i = 5, #KEYS do
+  redis.call("zadd", KEYS[i], ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// trimActivities removes activities from the index KEYS[1] and the list KEYS[2]. The
+// rest of the keys are each activities hash followed by its type indexes, ARGV has each
+// activities id and number of type indexes. The number removed is returned.
+var trimActivities = redis.NewScript(-1, `
+local n = 3
+for i = 1, #ARGV, 2 do
+  local id = ARGV[i]
+  local types = tonumber(ARGV[i + 1])
+  redis.call("del", KEYS[n])
+  for j = 1, types do
+    redis.call("zrem", KEYS[n + j], id)
+  end
+  n = n + types + 1
+  redis.call("lrem", KEYS[2], 0, id)
+  redis.call("zrem", KEYS[1], id)
+end
+return #ARGV / 2
+`)
+
+// publishEvent atomically numbers an event, keeps it for resuming streams, publishes it
+// to the users channel, and queues it for the users enabled webhooks in KEYS[3].
+var publishEvent = redis.NewScript(4, `
+local id = redis.call("incr", KEYS[2])
+local entry = id .. "\n" .. ARGV[2] .. "\n" .. ARGV[3]
+local max = tonumber(ARGV[4])
+if max > 0 then
+  redis.call("zadd", KEYS[1], id, entry)
+  redis.call("zremrangebyrank", KEYS[1], 0, -max - 1)
+end
+redis.call("publish", ARGV[1], entry)
+for _, webhook in ipairs(redis.call("smembers", KEYS[3])) do
+  redis.call("zadd", KEYS[4], ARGV[5], "0\n" .. ARGV[6] .. "\n" .. webhook .. "\n" .. entry)
+end
+return id
+`)
+
+// recordChange atomically numbers a change to an item and moves the item to the end of the
+// change log, so the log only has the latest change for each item.
+var recordChange = redis.NewScript(2, `
+local id = redis.call("incr", KEYS[2])
+redis.call("zadd", KEYS[1], id, ARGV[1])
+return id
+`)
+
+// transactionScripts are the scripts that may be run by saves inside a transaction.
+var transactionScripts = []*redis.Script{indexDocument, untagTask, appendTask, moveTask,
+	saveActivity, publishEvent, recordChange}
+
 // connect creates a redis.Conn for pool connections.
 func connect() (redis.Conn, error) {
 	return redis.DialTimeout(Config.DBNetwork, Config.DBAddr, Config.DBMaxTimeout,
@@ -50,7 +293,7 @@ func NewDBPool() *DBPool {
 
 // Get gets a connection and wraps it a Conn.
 func (pool *DBPool) Get() *Conn {
-	return &Conn{pool.Pool.Get()}
+	return &Conn{Conn: pool.Pool.Get(), pool: pool}
 }
 
 // Close delegates to the redis.Pool.Close.
@@ -58,9 +301,82 @@ func (pool *DBPool) Close() error {
 	return pool.Pool.Close()
 }
 
-// Conn wraps redis.Conn adding methods for data management.
+// Conn wraps redis.Conn adding methods for data management. Pool is the pool the
+// connection came from, if any. Multi is true while a transaction is queuing commands, and
+// applied has the functions run once it's applied.
 type Conn struct {
 	redis.Conn
+	pool    *DBPool
+	multi   bool
+	applied []func() error
+}
+
+// Transaction runs fn in a MULTI/EXEC block so its commands are applied atomically. Replies are
+// queued until the end, so fn should only write, work that needs replies is added to applied.
+// If a watched key changes ErrTransactionAborted is returned and nothing is applied.
+func (conn *Conn) Transaction(fn func() error) error {
+	// Scripts that aren't cached only fail once EXEC is reached, so ensure they're loaded
+	for _, script := range transactionScripts {
+		err := script.Load(conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Do("multi")
+	if err != nil {
+		return err
+	}
+
+	conn.multi = true
+	conn.applied = nil
+	err = fn()
+	conn.multi = false
+	if err != nil {
+		conn.Do("discard")
+		return err
+	}
+
+	reply, err := redis.Values(conn.Do("exec"))
+	if err == redis.ErrNil {
+		return ErrTransactionAborted
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, item := range reply {
+		if err, ok := item.(redis.Error); ok {
+			return err
+		}
+	}
+
+	for _, fn := range conn.applied {
+		err = fn()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// incr increments a counter and returns its value. Inside a transaction replies are only
+// queued, so the counter is incremented on another connection from the pool; it doesn't
+// need to be atomic with the transaction, since numbers that go unused are just skipped.
+func (conn *Conn) incr(key string) (int, error) {
+	if !conn.multi {
+		return redis.Int(conn.Do("incr", key))
+	}
+
+	if conn.pool == nil {
+		return 0, ErrTransactionIncr
+	}
+
+	other := conn.pool.Get()
+	defer other.Close()
+
+	return redis.Int(other.Do("incr", key))
 }
 
 // exists is a generic check for any key.
@@ -95,6 +411,9 @@ func (conn *Conn) GetUser(name string) (*User, error) {
 	if len(reply) <= 0 {
 		user = nil
 	}
+	if user != nil {
+		user.Muted = ParseTags(user.MutedStr)
+	}
 
 	return user, err
 }
@@ -115,7 +434,12 @@ func (conn *Conn) GetUserByToken(token string) (*User, error) {
 		return nil, nil
 	}
 
-	return conn.GetUser(tok.User)
+	user, err := conn.GetUser(tok.User)
+	if user != nil {
+		user.Device = tok.Device
+	}
+
+	return user, err
 }
 
 // GetDevices retrieves a users devices.
@@ -159,428 +483,3016 @@ func (conn *Conn) GetDevice(user, name string) (*Device, error) {
 	return device, err
 }
 
-// GetActivities retrieves a users activities.
-func (conn *Conn) GetActivities(user string) ([]*Activity, error) {
-	key := strings.Replace(ActivitiesKey, "{{user}}", user, -1)
+// PublishEvent publishes an event to the users streams. The reply is ignored so it can be
+// used inside a transaction, where it's published once applied.
+func (conn *Conn) PublishEvent(user, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
 
-	reply, err := redis.Strings(conn.Do("lrange", key, 0, -1))
+	_, err = publishEvent.Do(conn, strings.Replace(EventsKey, "{{user}}", user, -1),
+		strings.Replace(EventIDKey, "{{user}}", user, -1), strings.Replace(EnabledHooksKey, "{{user}}", user, -1),
+		WebhookQueueKey, strings.Replace(EventsChannel, "{{user}}", user, -1), eventType, encoded,
+		Config.EventMax, time.Now().Unix(), user)
+	return err
+}
+
+// GetEvents retrieves the kept events after an id. Complete is false if events after the id
+// are no longer kept, or the id is newer than the users latest event.
+func (conn *Conn) GetEvents(user string, after int64) ([]*Event, bool, error) {
+	key := strings.Replace(EventsKey, "{{user}}", user, -1)
+	reply, err := redis.Strings(conn.Do("zrangebyscore", key, "("+strconv.FormatInt(after, 10), "+inf"))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	activities := make([]*Activity, 0)
-	for _, item := range reply {
-		activity, err := conn.GetActivity(user, item)
-		if err != nil {
-			return nil, err
+	events := make([]*Event, 0, len(reply))
+	for _, entry := range reply {
+		event, err := ParseEvent(entry)
+		if err == nil {
+			events = append(events, event)
 		}
+	}
 
-		activities = append(activities, activity)
+	latest, err := redis.Int64(conn.Do("get", strings.Replace(EventIDKey, "{{user}}", user, -1)))
+	if err != nil && err != redis.ErrNil {
+		return nil, false, err
 	}
 
-	return activities, nil
+	if len(events) > 0 {
+		return events, events[0].ID == after+1, nil
+	}
+
+	return events, latest == after, nil
 }
 
-// GetActivity retrieves a activity.
-func (conn *Conn) GetActivity(user, time string) (*Activity, error) {
-	key := strings.Replace(ActivityKey, "{{user}}", user, -1)
+// DeleteEvents deletes the users kept events and event counter.
+func (conn *Conn) DeleteEvents(user string) error {
+	_, err := conn.Do("del", strings.Replace(EventsKey, "{{user}}", user, -1),
+		strings.Replace(EventIDKey, "{{user}}", user, -1))
+	return err
+}
 
-	reply, err := redis.Values(conn.Do("hgetall", strings.Replace(key, "{{activity}}", time, -1)))
-	if err != nil {
-		return nil, err
+// RecordChange records a change to a users item, given as <type>:<id>, in their change log.
+// The reply is ignored so it can be used inside a transaction.
+func (conn *Conn) RecordChange(user, item string) error {
+	_, err := recordChange.Do(conn, strings.Replace(ChangesKey, "{{user}}", user, -1),
+		strings.Replace(ChangeIDKey, "{{user}}", user, -1), item)
+	return err
+}
+
+// GetChangeID retrieves the id of the users latest change.
+func (conn *Conn) GetChangeID(user string) (int64, error) {
+	id, err := redis.Int64(conn.Do("get", strings.Replace(ChangeIDKey, "{{user}}", user, -1)))
+	if err == redis.ErrNil {
+		err = nil
 	}
 
-	activity := &Activity{Conn: conn}
-	err = redis.ScanStruct(reply, activity)
+	return id, err
+}
+
+// GetChanges retrieves up to limit items changed after a change id, with the id of their
+// latest change, in the order they changed.
+func (conn *Conn) GetChanges(user string, after int64, limit int) ([]string, []int64, error) {
+	reply, err := redis.Values(conn.Do("zrangebyscore", strings.Replace(ChangesKey, "{{user}}", user, -1),
+		"("+strconv.FormatInt(after, 10), "+inf", "withscores", "limit", 0, limit))
 	if err != nil {
-		activity = nil
+		return nil, nil, err
 	}
-	if len(reply) <= 0 {
-		activity = nil
+
+	items := make([]string, 0, len(reply)/2)
+	ids := make([]int64, 0, len(reply)/2)
+	for i := 0; i+1 < len(reply); i += 2 {
+		item, _ := redis.String(reply[i], nil)
+		id, _ := redis.Int64(reply[i+1], nil)
+
+		items = append(items, item)
+		ids = append(ids, id)
 	}
 
-	return activity, err
+	return items, ids, nil
 }
 
-// GetTasks retrieves a users tasks.
-func (conn *Conn) GetTasks(user string) ([]*Task, error) {
-	reply, err := redis.Strings(conn.Do("smembers", strings.Replace(TasksKey, "{{user}}", user, -1)))
+// DeleteChanges deletes the users change log and change counter.
+func (conn *Conn) DeleteChanges(user string) error {
+	_, err := conn.Do("del", strings.Replace(ChangesKey, "{{user}}", user, -1),
+		strings.Replace(ChangeIDKey, "{{user}}", user, -1))
+	return err
+}
+
+// GetWebhooks retrieves a users webhooks.
+func (conn *Conn) GetWebhooks(user string) ([]*Webhook, error) {
+	reply, err := redis.Strings(conn.Do("smembers", strings.Replace(WebhooksKey, "{{user}}", user, -1)))
 	if err != nil {
 		return nil, err
 	}
 
-	tasks := make([]*Task, 0)
-	for _, item := range reply {
-		task, err := conn.GetTask(user, item)
+	webhooks := make([]*Webhook, 0)
+	for _, id := range reply {
+		webhook, err := conn.GetWebhook(user, id)
 		if err != nil {
 			return nil, err
 		}
 
-		tasks = append(tasks, task)
+		if webhook != nil {
+			webhooks = append(webhooks, webhook)
+		}
 	}
 
-	return tasks, nil
+	return webhooks, nil
 }
 
-// GetTask retrieves a task.
-func (conn *Conn) GetTask(user, id string) (*Task, error) {
-	key := strings.Replace(TaskKey, "{{user}}", user, -1)
+// GetWebhook retrieves a users webhook by its id.
+func (conn *Conn) GetWebhook(user, id string) (*Webhook, error) {
+	key := strings.Replace(WebhookKey, "{{user}}", user, -1)
 
-	reply, err := redis.Values(conn.Do("hgetall", strings.Replace(key, "{{task}}", id, -1)))
+	reply, err := redis.Values(conn.Do("hgetall", strings.Replace(key, "{{webhook}}", id, -1)))
 	if err != nil {
 		return nil, err
 	}
 
-	task := &Task{Conn: conn}
-	err = redis.ScanStruct(reply, task)
+	webhook := &Webhook{Conn: conn}
+	err = redis.ScanStruct(reply, webhook)
 	if err != nil {
-		task = nil
+		return nil, err
 	}
 	if len(reply) <= 0 {
-		task = nil
+		return nil, nil
 	}
+	webhook.Events = ParseTags(webhook.EventsStr)
 
-	return task, err
+	return webhook, nil
 }
 
-// DeleteDevices deletes all a users devices
-func (conn *Conn) DeleteDevices(name string) error {
-	devices, err := conn.GetDevices(name)
+// GetDeliveries retrieves the logged deliveries for a webhook, most recent first.
+func (conn *Conn) GetDeliveries(user, id string) ([]*Delivery, error) {
+	key := strings.Replace(DeliveriesKey, "{{user}}", user, -1)
+
+	reply, err := redis.Strings(conn.Do("lrange", strings.Replace(key, "{{webhook}}", id, -1), 0, -1))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	user := &User{Name: name}
 
-	for _, device := range devices {
-		device.User = user
+	deliveries := make([]*Delivery, 0, len(reply))
+	for _, item := range reply {
+		delivery := new(Delivery)
 
-		err = device.Delete()
-		if err != nil {
-			return err
+		err = json.Unmarshal([]byte(item), delivery)
+		if err == nil {
+			deliveries = append(deliveries, delivery)
 		}
 	}
 
-	return nil
+	return deliveries, nil
 }
 
-// DeleteActivities deletes all a users activities
-func (conn *Conn) DeleteActivities(name string) error {
-	activities, err := conn.GetActivities(name)
+// DeleteWebhooks deletes all a users webhooks, queued deliveries are dropped when claimed.
+func (conn *Conn) DeleteWebhooks(name string) error {
+	webhooks, err := conn.GetWebhooks(name)
 	if err != nil {
 		return err
 	}
 	user := &User{Name: name}
 
-	// Delete activity hashes
-	for _, activity := range activities {
-		activity.User = user
+	for _, webhook := range webhooks {
+		webhook.User = user
 
-		err = activity.Delete()
+		err = webhook.Delete()
 		if err != nil {
 			return err
 		}
 	}
 
-	// Delete activity list here, since we can't remove list items individually easily
-	_, err = conn.Do("del", strings.Replace(ActivitiesKey, "{{user}}", name, -1))
-	return err
+	return nil
 }
 
-// DeleteTasks deletes all a users tasks
-func (conn *Conn) DeleteTasks(name string) error {
-	tasks, err := conn.GetTasks(name)
+// ClaimWebhookDeliveries leases up to limit queued deliveries due by now, requeuing any
+// whose lease has expired.
+func (conn *Conn) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]*QueuedDelivery,
+	error) {
+	_, err := requeueClaims.Do(conn, WebhookQueueKey, WebhookClaimKey, now.Unix())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	user := &User{Name: name}
 
-	for _, task := range tasks {
-		task.User = user
+	reply, err := redis.Strings(claimReminders.Do(conn, WebhookQueueKey, WebhookClaimKey, now.Unix(),
+		now.Add(lease).Unix(), limit))
+	if err != nil {
+		return nil, err
+	}
 
-		err = task.Delete()
+	queued := make([]*QueuedDelivery, 0, len(reply))
+	for _, item := range reply {
+		delivery, err := ParseQueuedDelivery(item)
 		if err != nil {
-			return err
+			// Invalid items would never be delivered
+			err = conn.AckWebhookDelivery(&QueuedDelivery{Item: item})
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
+
+		queued = append(queued, delivery)
 	}
 
-	// Delete task id counter
-	_, err = conn.Do("del", strings.Replace(TasksIDKey, "{{user}}", name, -1))
-	return err
+	return queued, nil
 }
 
-/*
-  User
-*/
-
-// User represents a single users hash data.
-type User struct {
-	*Conn    `json:"-" redis:"-"`
-	Name     string `json:"name" redis:"name"`
-	Password string `json:"-" redis:"password"`
+// AckWebhookDelivery removes a claimed delivery once it's done.
+func (conn *Conn) AckWebhookDelivery(queued *QueuedDelivery) error {
+	_, err := conn.Do("zrem", WebhookClaimKey, queued.Item)
+	return err
 }
 
-// Validate ensures the data is valid, if new it'll check if exists.
-func (user *User) Validate(new bool) ([]string, error) {
-	return Validations(func() (error, error) {
-		if user.Name == "" {
-			return ErrUserNameEmpty, nil
-		}
+// RetryWebhookDelivery atomically requeues a claimed delivery as its next attempt at the
+// given time.
+func (conn *Conn) RetryWebhookDelivery(queued *QueuedDelivery, at time.Time) error {
+	next := *queued
+	next.Attempt++
 
-		return nil, nil
-	}, func() (error, error) {
-		if user.Password == "" {
-			return ErrUserPasswordEmpty, nil
+	return conn.Transaction(func() error {
+		err := conn.AckWebhookDelivery(queued)
+		if err != nil {
+			return err
 		}
 
-		return nil, nil
-	}, func() (error, error) {
-		if !new || user.Name == "" {
-			return nil, nil
-		}
+		_, err = conn.Do("zadd", WebhookQueueKey, at.Unix(), next.String())
+		return err
+	})
+}
 
-		exists, err := user.UserExists(user.Name)
+// GetActivities retrieves a users activities.
+func (conn *Conn) GetActivities(user string) ([]*Activity, error) {
+	key := strings.Replace(ActivitiesKey, "{{user}}", user, -1)
+
+	reply, err := redis.Strings(conn.Do("lrange", key, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]*Activity, 0)
+	for _, item := range reply {
+		activity, err := conn.GetActivity(user, item)
 		if err != nil {
 			return nil, err
 		}
 
-		if exists {
-			return ErrUserAlreadyExists, nil
+		if activity != nil {
+			activities = append(activities, activity)
 		}
+	}
 
-		return nil, nil
-	})
+	return activities, nil
 }
 
-// Save saves the user data, hashing the password if needed.
-func (user *User) Save(genHash bool) error {
-	if genHash {
-		pass, err := bcrypt.GenerateFromPassword([]byte(user.Password), -1)
+// GetActivitiesRange retrieves a users activities with scores between min and max, most
+// recent first. Min and max are Redis score ranges, the offset and count limit the results.
+// If a type is given only activities with the type, or in the category, are retrieved.
+func (conn *Conn) GetActivitiesRange(user, activityType, min, max string, offset, count int) ([]*Activity, error) {
+	err := conn.indexActivities(user)
+	if err != nil {
+		return nil, err
+	}
+
+	key := strings.Replace(ActivityIndexKey, "{{user}}", user, -1)
+	if activityType != "" {
+		key = strings.Replace(ActivityTypeKey, "{{user}}", user, -1)
+		key = strings.Replace(key, "{{type}}", activityType, -1)
+	}
+
+	reply, err := redis.Strings(conn.Do("zrevrangebyscore", key, max, min, "withscores", "limit",
+		offset, count))
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]*Activity, 0)
+	for i := 0; i < len(reply); i += 2 {
+		activity, err := conn.GetActivity(user, reply[i])
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		user.Password = string(pass)
+		if activity != nil {
+			activity.score, _ = strconv.ParseInt(reply[i+1], 10, 64)
+			activities = append(activities, activity)
+		}
 	}
 
-	key := strings.Replace(UserKey, "{{user}}", user.Name, -1)
-	_, err := user.Do("hmset", redis.Args{}.Add(key).AddFlat(user)...)
-	return err
+	return activities, nil
 }
 
-// Delete removes the user data.
-func (user *User) Delete() error {
-	_, err := user.Do("del", strings.Replace(UserKey, "{{user}}", user.Name, -1))
+// indexActivities adds a users activities to the time and type indexes if they were
+// saved before they existed. Duplicate and invalid list items are removed so the list
+// and index stay the same size.
+func (conn *Conn) indexActivities(user string) error {
+	key := strings.Replace(ActivityIndexKey, "{{user}}", user, -1)
+	listKey := strings.Replace(ActivitiesKey, "{{user}}", user, -1)
 
-	return err
-}
+	length, err := redis.Int(conn.Do("llen", listKey))
+	if err != nil {
+		return err
+	}
 
-/*
-  Device
-*/
+	indexed, err := redis.Int(conn.Do("zcard", key))
+	if err != nil || length <= indexed {
+		return err
+	}
 
-// Device represents a single device hash for a user.
-type Device struct {
-	*Conn `json:"-" redis:"-"`
-	Name  string `json:"name" redis:"name"`
-	Token string `json:"token" redis:"token"`
-	User  *User  `json:"-" redis:"-"`
-}
+	reply, err := redis.Strings(conn.Do("lrange", listKey, 0, -1))
+	if err != nil {
+		return err
+	}
 
-// Validate ensures the data is valid, if new it'll check if it exists.
-func (device *Device) Validate(new bool) ([]string, error) {
-	return Validations(func() (error, error) {
-		if device.Name == "" {
-			return ErrDeviceNameEmpty, nil
-		}
+	// Numbered activities are indexed when they're saved, only those from before then have
+	// their time as their id
+	current, err := redis.Strings(conn.Do("zrange", key, 0, -1))
+	if err != nil {
+		return err
+	}
 
-		return nil, nil
-	}, func() (error, error) {
-		if !new || device.Name == "" {
-			return nil, nil
+	saved := make(map[string]bool, len(current))
+	for _, item := range current {
+		saved[item] = true
+	}
+
+	args := redis.Args{}.Add(key)
+	counts := make(map[string]int)
+	scores := make(map[string]int64)
+	for _, item := range reply {
+		counts[item]++
+		if counts[item] > 1 || saved[item] {
+			continue
 		}
 
-		exists, err := device.DeviceExists(device.User.Name, device.Name)
+		date, err := time.Parse(time.RFC3339Nano, item)
+		if err != nil {
+			counts[item] = 0
+			continue
+		}
+
+		scores[item] = ActivityScore(date)
+		args = args.Add(scores[item], item)
+	}
+
+	if len(args) > 1 {
+		_, err = conn.Do("zadd", args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The type indexes are the rest of the activities index keys
+	activityKey := strings.Replace(ActivityKey, "{{user}}", user, -1)
+	for id, score := range scores {
+		activityType, err := redis.String(conn.Do("hget", strings.Replace(activityKey, "{{activity}}",
+			id, -1), "type"))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+
+		activity := &Activity{ID: id, Type: activityType, User: &User{Name: user}}
+		for _, typeKey := range activity.indexKeys()[1:] {
+			_, err = conn.Do("zadd", typeKey, score, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for item, count := range counts {
+		if count == 1 {
+			continue
+		}
+
+		// Invalid items are all removed, duplicates keep one
+		remove := count - 1
+		if count == 0 {
+			remove = 0
+		}
+
+		_, err = conn.Do("lrem", listKey, remove, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trimActivities removes a users activities over the configured count or older than the
+// configured retention, at most batch are removed. The number removed is returned.
+func (conn *Conn) trimActivities(user string, batch int) (int, error) {
+	key := strings.Replace(ActivityIndexKey, "{{user}}", user, -1)
+
+	remove := 0
+	if Config.ActivityMax > 0 {
+		count, err := redis.Int(conn.Do("zcard", key))
+		if err != nil {
+			return 0, err
+		}
+
+		remove = count - Config.ActivityMax
+	}
+
+	if Config.ActivityRetention > 0 {
+		min := ActivityScore(time.Now().Add(-Config.ActivityRetention))
+		old, err := redis.Int(conn.Do("zcount", key, "-inf", "("+strconv.FormatInt(min, 10)))
+		if err != nil {
+			return 0, err
+		}
+
+		if old > remove {
+			remove = old
+		}
+	}
+
+	if remove > batch {
+		remove = batch
+	}
+	if remove <= 0 {
+		return 0, nil
+	}
+
+	ids, err := redis.Strings(conn.Do("zrange", key, 0, remove-1))
+	if err != nil {
+		return 0, err
+	}
+
+	// The keys of each activity are got first so they can be given to the script
+	keys := []string{key, strings.Replace(ActivitiesKey, "{{user}}", user, -1)}
+	args := redis.Args{}
+	activityKey := strings.Replace(ActivityKey, "{{user}}", user, -1)
+	for _, id := range ids {
+		itemKey := strings.Replace(activityKey, "{{activity}}", id, -1)
+
+		activityType, err := redis.String(conn.Do("hget", itemKey, "type"))
+		if err != nil && err != redis.ErrNil {
+			return 0, err
+		}
+
+		activity := &Activity{ID: id, Type: activityType, User: &User{Name: user}}
+		typeKeys := activity.indexKeys()[1:]
+		keys = append(append(keys, itemKey), typeKeys...)
+		args = args.Add(id, len(typeKeys))
+	}
+
+	return redis.Int(trimActivities.Do(conn, redis.Args{}.Add(len(keys)).AddFlat(keys).Add(args...)...))
+}
+
+// TrimActivities indexes a users older activities and trims them to the configured
+// retention, returning the number removed.
+func (conn *Conn) TrimActivities(user string, batch int) (int, error) {
+	err := conn.indexActivities(user)
+	if err != nil {
+		return 0, err
+	}
+
+	return conn.trimActivities(user, batch)
+}
+
+// GetActivity retrieves a activity.
+func (conn *Conn) GetActivity(user, id string) (*Activity, error) {
+	key := strings.Replace(ActivityKey, "{{user}}", user, -1)
+
+	reply, err := redis.Values(conn.Do("hgetall", strings.Replace(key, "{{activity}}", id, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	activity := &Activity{Conn: conn}
+	err = redis.ScanStruct(reply, activity)
+	if err != nil {
+		activity = nil
+	}
+	if len(reply) <= 0 {
+		activity = nil
+	}
+
+	// Activities saved before they had ids use their time
+	if activity != nil && activity.ID == "" {
+		activity.ID = id
+	}
+
+	if activity != nil {
+		activity.Meta = make(map[string]string)
+		if activity.MetaStr != "" {
+			err = json.Unmarshal([]byte(activity.MetaStr), &activity.Meta)
+		}
+	}
+
+	return activity, err
+}
+
+// GetTasks retrieves a users tasks.
+func (conn *Conn) GetTasks(user string) ([]*Task, error) {
+	reply, err := redis.Strings(conn.Do("smembers", strings.Replace(TasksKey, "{{user}}", user, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.getTasks(user, reply)
+}
+
+// GetTasksByTags retrieves a users tasks that have all the given tags, or any of them if all
+// is false.
+func (conn *Conn) GetTasksByTags(user string, tags []string, all bool) ([]*Task, error) {
+	args := redis.Args{}
+	for _, tag := range tags {
+		key := strings.Replace(TagKey, "{{user}}", user, -1)
+		args = args.Add(strings.Replace(key, "{{tag}}", tag, -1))
+	}
+
+	cmd := "sunion"
+	if all {
+		cmd = "sinter"
+	}
+
+	reply, err := redis.Strings(conn.Do(cmd, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.getTasks(user, reply)
+}
+
+// getTasks retrieves a users tasks from their ids.
+func (conn *Conn) getTasks(user string, ids []string) ([]*Task, error) {
+	tasks := make([]*Task, 0)
+	for _, item := range ids {
+		task, err := conn.GetTask(user, item)
+		if err != nil {
+			return nil, err
+		}
+
+		if task != nil {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Sort(tasksByPosition(tasks))
+
+	return tasks, nil
+}
+
+// GetTask retrieves a task, tasks in the trash aren't retrieved.
+func (conn *Conn) GetTask(user, id string) (*Task, error) {
+	task, err := conn.getTask(user, id)
+	if task != nil && task.Deleted != "" {
+		task = nil
+	}
+
+	return task, err
+}
+
+// WatchSyncRefs watches a users sync refs so a following transaction fails if a task is
+// created for one.
+func (conn *Conn) WatchSyncRefs(user string) error {
+	_, err := conn.Do("watch", strings.Replace(SyncRefsKey, "{{user}}", user, -1))
+	return err
+}
+
+// GetSyncRefTask retrieves the id of the task created by the sync upload with a ref, returning
+// an empty id if none was.
+func (conn *Conn) GetSyncRefTask(user, ref string) (string, error) {
+	id, err := redis.String(conn.Do("hget", strings.Replace(SyncRefsKey, "{{user}}", user, -1), ref))
+	if err == redis.ErrNil {
+		err = nil
+	}
+
+	return id, err
+}
+
+// GetTaskByUID retrieves a task by its calendar uid, returning nil if no task has it.
+func (conn *Conn) GetTaskByUID(user, uid string) (*Task, error) {
+	id, err := redis.String(conn.Do("hget", strings.Replace(UIDsKey, "{{user}}", user, -1), uid))
+	if err == redis.ErrNil {
+		// Tasks without an imported uid use one generated from their id
+		prefix := user + "-"
+		if !strings.HasPrefix(uid, prefix) || !strings.HasSuffix(uid, CalendarUIDSuffix) {
+			return nil, nil
+		}
+
+		id = strings.TrimSuffix(strings.TrimPrefix(uid, prefix), CalendarUIDSuffix)
+		if _, err = strconv.Atoi(id); err != nil {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	task, err := conn.GetTask(user, id)
+	if err != nil || task == nil {
+		return nil, err
+	}
+
+	// A generated uid doesn't match a task that was imported with its own
+	if task.CalendarUID() != uid {
+		return nil, nil
+	}
+
+	return task, nil
+}
+
+// GetTrashTask retrieves a task from the trash.
+func (conn *Conn) GetTrashTask(user, id string) (*Task, error) {
+	task, err := conn.getTask(user, id)
+	if task != nil && task.Deleted == "" {
+		task = nil
+	}
+
+	return task, err
+}
+
+// GetTrash retrieves a users tasks in the trash, most recently deleted first.
+func (conn *Conn) GetTrash(user string) ([]*Task, error) {
+	reply, err := redis.Strings(conn.Do("zrevrange", strings.Replace(TrashKey, "{{user}}", user, -1), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, 0)
+	for _, item := range reply {
+		task, err := conn.GetTrashTask(user, item)
+		if err != nil {
+			return nil, err
+		}
+
+		if task != nil {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks, nil
+}
+
+// GetExpiredTrash retrieves up to limit tasks trashed before the given time, given as
+// <user>:<task>. They stay queued for purging until they're deleted.
+func (conn *Conn) GetExpiredTrash(before time.Time, limit int) ([]string, error) {
+	return redis.Strings(conn.Do("zrangebyscore", PurgeKey, "-inf", before.Unix(), "limit", 0, limit))
+}
+
+// UnqueueTrash removes a task that's no longer in the trash from the tasks queued for purging.
+func (conn *Conn) UnqueueTrash(item string) error {
+	_, err := conn.Do("zrem", PurgeKey, item)
+	return err
+}
+
+// getTask retrieves a task whether or not it's in the trash.
+func (conn *Conn) getTask(user, id string) (*Task, error) {
+	key := strings.Replace(TaskKey, "{{user}}", user, -1)
+
+	reply, err := redis.Values(conn.Do("hgetall", strings.Replace(key, "{{task}}", id, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	task := &Task{Conn: conn}
+	err = redis.ScanStruct(reply, task)
+	if err != nil {
+		task = nil
+	}
+	if len(reply) <= 0 {
+		task = nil
+	}
+
+	if task != nil {
+		task.Tags = ParseTags(task.TagsStr)
+		task.User = &User{Conn: conn, Name: user}
+		task.snapshot()
+
+		key = strings.Replace(CategoryKey, "{{user}}", user, -1)
+		key = strings.Replace(key, "{{category}}", task.Category, -1)
+		task.Position, err = redis.Float64(conn.Do("zscore", key, id))
+		if err == redis.ErrNil {
+			// Tasks saved before categories were ordered are added to the end of their
+			// order the next time they're saved
+			err = nil
+			task.unordered = true
+		}
+	}
+
+	return task, err
+}
+
+// WatchTask watches a users task so a following transaction fails if it's modified.
+func (conn *Conn) WatchTask(user, id string) error {
+	key := strings.Replace(TaskKey, "{{user}}", user, -1)
+
+	_, err := conn.Do("watch", strings.Replace(key, "{{task}}", id, -1))
+	return err
+}
+
+// GetTaskHistory retrieves a tasks changes, most recent first.
+func (conn *Conn) GetTaskHistory(user, id string) ([]*TaskChange, error) {
+	key := strings.Replace(HistoryKey, "{{user}}", user, -1)
+
+	reply, err := redis.Strings(conn.Do("lrange", strings.Replace(key, "{{task}}", id, -1), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*TaskChange, 0)
+	for _, item := range reply {
+		change := new(TaskChange)
+
+		err = json.Unmarshal([]byte(item), change)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// NextTaskID generates the next id for a users task.
+func (conn *Conn) NextTaskID(user string) (int, error) {
+	return redis.Int(conn.Do("incr", strings.Replace(TasksIDKey, "{{user}}", user, -1)))
+}
+
+// GetTags retrieves a users tags with the number of tasks for each.
+func (conn *Conn) GetTags(user string) ([]*Tag, error) {
+	key := strings.Replace(TagsKey, "{{user}}", user, -1)
+
+	reply, err := redis.Strings(conn.Do("smembers", key))
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]*Tag, 0)
+	for _, item := range reply {
+		tag, err := conn.GetTag(user, item)
 		if err != nil {
 			return nil, err
 		}
 
-		if exists {
-			return ErrDeviceAlreadyExists, nil
+		if tag != nil {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
+
+// GetTag retrieves a tag, tags without any tasks don't exist.
+func (conn *Conn) GetTag(user, name string) (*Tag, error) {
+	key := strings.Replace(TagKey, "{{user}}", user, -1)
+
+	count, err := redis.Int(conn.Do("scard", strings.Replace(key, "{{tag}}", name, -1)))
+	if err != nil || count <= 0 {
+		return nil, err
+	}
+
+	return &Tag{Name: name, Count: count}, nil
+}
+
+// renameRetries is how many times a tag rename is tried if its tasks change while renaming.
+const renameRetries = 3
+
+// RenameTag renames a users tag on each of its tasks in a single transaction, merging it if
+// the new name is already in use. The tag and its tasks are watched, so if they change while
+// renaming it's tried again.
+func (conn *Conn) RenameTag(user, name, newName string) error {
+	key := strings.Replace(TagKey, "{{user}}", user, -1)
+	key = strings.Replace(key, "{{tag}}", name, -1)
+
+	for attempt := 0; ; attempt++ {
+		tasks, err := conn.watchTagTasks(user, key)
+		if err != nil {
+			conn.Do("unwatch")
+			return err
+		}
+
+		err = conn.Transaction(func() error {
+			for _, task := range tasks {
+				tags := make([]string, 0)
+				for _, tag := range task.Tags {
+					if tag == name {
+						tag = newName
+					}
+
+					tags = append(tags, tag)
+				}
+				task.Tags = ParseTags(strings.Join(tags, ","))
+
+				err := task.Save(false)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err := conn.Do("srem", strings.Replace(TagsKey, "{{user}}", user, -1), name)
+			return err
+		})
+		if err == ErrTransactionAborted && attempt < renameRetries {
+			continue
+		}
+
+		return err
+	}
+}
+
+// watchTagTasks watches a tag set and each of its tasks, and retrieves the tasks.
+func (conn *Conn) watchTagTasks(user, key string) ([]*Task, error) {
+	_, err := conn.Do("watch", key)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := redis.Strings(conn.Do("smembers", key))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, 0)
+	for _, id := range ids {
+		err = conn.WatchTask(user, id)
+		if err != nil {
+			return nil, err
+		}
+
+		task, err := conn.GetTask(user, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if task != nil {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks, nil
+}
+
+// GetLists retrieves the lists a user owns or is a member of.
+func (conn *Conn) GetLists(user string) ([]*List, error) {
+	reply, err := redis.Strings(conn.Do("smembers", strings.Replace(UserListsKey, "{{user}}", user, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]*List, 0)
+	for _, item := range reply {
+		list, err := conn.GetList(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if list != nil {
+			lists = append(lists, list)
+		}
+	}
+
+	return lists, nil
+}
+
+// GetList retrieves a list and its members.
+func (conn *Conn) GetList(id string) (*List, error) {
+	reply, err := redis.Values(conn.Do("hgetall", strings.Replace(ListKey, "{{list}}", id, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	list := &List{Conn: conn}
+	err = redis.ScanStruct(reply, list)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) <= 0 {
+		return nil, nil
+	}
+
+	members, err := redis.Values(conn.Do("hgetall", strings.Replace(MembersKey, "{{list}}", id, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	list.Members = make(map[string]string)
+	for i := 0; i+1 < len(members); i += 2 {
+		member, _ := redis.String(members[i], nil)
+		role, _ := redis.String(members[i+1], nil)
+
+		list.Members[member] = role
+	}
+
+	return list, nil
+}
+
+// GetListTasks retrieves the tasks in a list.
+func (conn *Conn) GetListTasks(list *List) ([]*Task, error) {
+	reply, err := redis.Strings(conn.Do("smembers", strings.Replace(ListTasksKey, "{{list}}", list.ID, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.getTasks(list.Owner, reply)
+}
+
+// GetSharedTasks retrieves the tasks in the lists shared with a user, the users own
+// lists aren't included. The tasks have their owner set since their ids are the owners.
+func (conn *Conn) GetSharedTasks(user string) ([]*Task, error) {
+	lists, err := conn.GetLists(user)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, 0)
+	for _, list := range lists {
+		if list.Owner == user {
+			continue
+		}
+
+		listTasks, err := conn.GetListTasks(list)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range listTasks {
+			task.Owner = list.Owner
+		}
+		tasks = append(tasks, listTasks...)
+	}
+
+	return tasks, nil
+}
+
+// GetAssignedTasks retrieves the tasks assigned to a user, including other users tasks.
+func (conn *Conn) GetAssignedTasks(user string) ([]*Task, error) {
+	reply, err := redis.Strings(conn.Do("smembers", strings.Replace(AssignedKey, "{{user}}", user, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, 0)
+	for _, item := range reply {
+		i := strings.LastIndex(item, ":")
+
+		owner := item[:i]
+
+		task, err := conn.GetTask(owner, item[i+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if task != nil {
+			if owner != user {
+				task.Owner = owner
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Sort(tasksByPosition(tasks))
+
+	return tasks, nil
+}
+
+// UnassignTasks unassigns every task assigned to a user.
+func (conn *Conn) UnassignTasks(user string) error {
+	tasks, err := conn.GetAssignedTasks(user)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		err = conn.updateTask(task.User.Name, strconv.Itoa(task.ID), func(task *Task) bool {
+			if task.Assignee != user {
+				return false
+			}
+
+			task.Assignee = ""
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateRetries is how many times a task changed on behalf of another change is tried if
+// the task changes while saving.
+const updateRetries = 3
+
+// updateTask saves the changes fn makes to a users task in a transaction. The task is
+// watched before it's retrieved, so if it changes while saving it's tried again. Fn returns
+// false if the task doesn't need saving, tasks that no longer exist are skipped.
+func (conn *Conn) updateTask(user, id string, fn func(task *Task) bool) error {
+	for attempt := 0; ; attempt++ {
+		err := conn.WatchTask(user, id)
+		if err != nil {
+			return err
+		}
+
+		task, err := conn.GetTask(user, id)
+		if err != nil || task == nil || !fn(task) {
+			conn.Do("unwatch")
+			return err
+		}
+
+		err = conn.Transaction(func() error {
+			return task.Save(false)
+		})
+		if err == ErrTransactionAborted && attempt < updateRetries {
+			continue
+		}
+
+		return err
+	}
+}
+
+// ClaimReminders leases up to limit reminders due by now, requeuing any whose
+// previous lease has expired. Each reminder is given to a single caller.
+func (conn *Conn) ClaimReminders(now time.Time, lease time.Duration, limit int) ([]string, error) {
+	err := conn.requeueReminders(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return redis.Strings(claimReminders.Do(conn, RemindersKey, ClaimedKey, now.Unix(),
+		now.Add(lease).Unix(), limit))
+}
+
+// requeueReminders moves claimed reminders whose lease has expired back to the reminders
+// set, at their tasks current reminder time. Reminders for tasks that are gone, complete or
+// no longer have a reminder are due immediately, so they're dropped once claimed.
+func (conn *Conn) requeueReminders(now time.Time) error {
+	expired, err := redis.Strings(conn.Do("zrangebyscore", ClaimedKey, "-inf", now.Unix()))
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+
+	args := []interface{}{RemindersKey, ClaimedKey, now.Unix()}
+	for _, reminder := range expired {
+		score := now.Unix()
+
+		i := strings.LastIndex(reminder, ":")
+		if i >= 0 {
+			task, err := conn.GetTask(reminder[:i], reminder[i+1:])
+			if err != nil {
+				return err
+			}
+
+			if task != nil && !task.Complete {
+				remind, err := time.Parse(time.RFC3339, task.Remind)
+				if err == nil {
+					score = remind.Unix()
+				}
+			}
+		}
+
+		args = append(args, reminder, score)
+	}
+
+	_, err = requeueReminders.Do(conn, args...)
+	return err
+}
+
+// GetReminderDeliveries retrieves the notifiers that have delivered a claimed reminder, with
+// the reminder time each delivered.
+func (conn *Conn) GetReminderDeliveries(reminder string) (map[string]string, error) {
+	return redis.StringMap(conn.Do("hgetall", strings.Replace(DeliveredKey, "{{reminder}}", reminder, -1)))
+}
+
+// RecordReminderDelivery records that a notifier delivered a claimed reminder for the given
+// reminder time.
+func (conn *Conn) RecordReminderDelivery(reminder, notifier, remind string) error {
+	_, err := conn.Do("hset", strings.Replace(DeliveredKey, "{{reminder}}", reminder, -1), notifier, remind)
+	return err
+}
+
+// AckReminder removes a claimed reminder once it's been delivered.
+func (conn *Conn) AckReminder(reminder string) error {
+	_, err := conn.Do("zrem", ClaimedKey, reminder)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("del", strings.Replace(DeliveredKey, "{{reminder}}", reminder, -1))
+	return err
+}
+
+// Index replaces the indexed terms for a users searchable item.
+// Previous is the item as it was last indexed, or nil if it hasn't been.
+func (conn *Conn) Index(user string, item, previous Searchable) error {
+	var previousTerms map[string]int
+	if previous != nil {
+		previousTerms = Terms(previous.SearchText()...)
+	}
+
+	return conn.index(user, item.SearchDoc(), previousTerms, Terms(item.SearchText()...))
+}
+
+// Unindex removes a users searchable item, as it was last indexed, from the index.
+func (conn *Conn) Unindex(user string, item Searchable) error {
+	return conn.index(user, item.SearchDoc(), Terms(item.SearchText()...), nil)
+}
+
+// index runs the index script for a document, replacing the previous terms with the new
+// terms and their frequencies.
+func (conn *Conn) index(user, doc string, previous, terms map[string]int) error {
+	docKey := strings.Replace(SearchDocKey, "{{user}}", user, -1)
+	docKey = strings.Replace(docKey, "{{doc}}", doc, -1)
+	termKey := strings.Replace(SearchTermKey, "{{user}}", user, -1)
+
+	keys := redis.Args{}.Add(strings.Replace(SearchKey, "{{user}}", user, -1), docKey)
+	for term := range previous {
+		keys = keys.Add(strings.Replace(termKey, "{{term}}", term, -1))
+	}
+
+	args := redis.Args{}.Add(doc, len(previous))
+	for term, freq := range terms {
+		keys = keys.Add(strings.Replace(termKey, "{{term}}", term, -1))
+		args = args.Add(term, freq)
+	}
+
+	_, err := indexDocument.Do(conn, redis.Args{}.Add(len(keys)).Add(keys...).Add(args...)...)
+	return err
+}
+
+// Search retrieves the documents matching any of the terms, scored by tf-idf and
+// sorted with the most relevant first.
+func (conn *Conn) Search(user string, terms []string) ([]*SearchResult, error) {
+	total, err := redis.Int(conn.Do("scard", strings.Replace(SearchKey, "{{user}}", user, -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		key := strings.Replace(SearchTermKey, "{{user}}", user, -1)
+
+		reply, err := redis.Strings(conn.Do("zrange", strings.Replace(key, "{{term}}", term, -1),
+			0, -1, "withscores"))
+		if err != nil {
+			return nil, err
+		}
+		if len(reply) <= 0 {
+			continue
+		}
+
+		// Rarer terms count for more
+		idf := math.Log(1 + float64(total)/float64(len(reply)/2))
+		for i := 0; i < len(reply); i += 2 {
+			freq, err := strconv.ParseFloat(reply[i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+
+			scores[reply[i]] += freq * idf
+		}
+	}
+
+	results := make([]*SearchResult, 0)
+	for doc, score := range scores {
+		split := strings.SplitN(doc, ":", 2)
+		results = append(results, &SearchResult{Type: split[0], ID: split[1], Score: score})
+	}
+	sort.Sort(searchResults(results))
+
+	return results, nil
+}
+
+// DeleteDevices deletes all a users devices
+func (conn *Conn) DeleteDevices(name string) error {
+	devices, err := conn.GetDevices(name)
+	if err != nil {
+		return err
+	}
+	user := &User{Name: name}
+
+	for _, device := range devices {
+		device.User = user
+
+		err = device.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteLists deletes the lists a user owns and removes them from the lists shared with them.
+func (conn *Conn) DeleteLists(name string) error {
+	lists, err := conn.GetLists(name)
+	if err != nil {
+		return err
+	}
+
+	for _, list := range lists {
+		if list.Owner == name {
+			err = list.Delete()
+		} else {
+			err = list.RemoveMember(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.Do("del", strings.Replace(UserListsKey, "{{user}}", name, -1))
+	return err
+}
+
+// DeleteActivities deletes all a users activities
+func (conn *Conn) DeleteActivities(name string) error {
+	activities, err := conn.GetActivities(name)
+	if err != nil {
+		return err
+	}
+	user := &User{Name: name}
+
+	// Delete activity hashes
+	for _, activity := range activities {
+		activity.User = user
+
+		err = activity.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete activity list here, since we can't remove list items individually easily
+	_, err = conn.Do("del", strings.Replace(ActivitiesKey, "{{user}}", name, -1))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("del", strings.Replace(ActivityIndexKey, "{{user}}", name, -1))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("del", strings.Replace(ActivityIDKey, "{{user}}", name, -1))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("srem", ActivityUsersKey, name)
+	return err
+}
+
+// DeleteTasks deletes all a users tasks
+func (conn *Conn) DeleteTasks(name string) error {
+	tasks, err := conn.GetTasks(name)
+	if err != nil {
+		return err
+	}
+	user := &User{Name: name}
+
+	for _, task := range tasks {
+		task.User = user
+
+		err = task.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	trash, err := conn.GetTrash(name)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range trash {
+		task.User = user
+
+		err = task.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete task id counter
+	_, err = conn.Do("del", strings.Replace(TasksIDKey, "{{user}}", name, -1))
+	if err != nil {
+		return err
+	}
+
+	// Delete tag names, the tag sets are emptied as the tasks are deleted
+	_, err = conn.Do("del", strings.Replace(TagsKey, "{{user}}", name, -1))
+	return err
+}
+
+/*
+  User
+*/
+
+// User represents a single users hash data.
+type User struct {
+	*Conn    `json:"-" redis:"-"`
+	Name     string   `json:"name" redis:"name"`
+	Password string   `json:"-" redis:"password"`
+	Email    string   `json:"email" redis:"email"`
+	Muted    []string `json:"muted" redis:"-"`
+	MutedStr string   `json:"-" redis:"muted"`
+	Device   string   `json:"-" redis:"-"`
+}
+
+// Validate ensures the data is valid, if new it'll check if exists.
+func (user *User) Validate(new bool) ([]string, error) {
+	return Validations(func() (error, error) {
+		if user.Name == "" {
+			return ErrUserNameEmpty, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if user.Password == "" {
+			return ErrUserPasswordEmpty, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if user.Email == "" {
+			return nil, nil
+		}
+
+		// Only a bare address is accepted, since it's what notifications are sent to
+		address, err := mail.ParseAddress(user.Email)
+		if err != nil || address.Address != user.Email {
+			return ErrUserEmailInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if !new || user.Name == "" {
+			return nil, nil
+		}
+
+		exists, err := user.UserExists(user.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			return ErrUserAlreadyExists, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		for _, muted := range user.Muted {
+			if !ValidActivityType(muted) {
+				return ErrUserMutedInvalid, nil
+			}
+		}
+
+		return nil, nil
+	})
+}
+
+// Mutes checks if the user has turned off activities of a type, either by the type or
+// its category.
+func (user *User) Mutes(activityType string) bool {
+	for _, muted := range user.Muted {
+		if muted == activityType || muted == ActivityCategory(activityType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Patch applies a merge patch to the patchable user fields.
+func (user *User) Patch(patch map[string]interface{}) []string {
+	return ApplyPatch("User", patch, map[string]*PatchField{
+		"password": PatchString(&user.Password),
+		"email":    PatchString(&user.Email),
+		"muted":    PatchStrings(&user.Muted),
+	})
+}
+
+// Save saves the user data, hashing the password if needed.
+func (user *User) Save(genHash bool) error {
+	if genHash {
+		pass, err := bcrypt.GenerateFromPassword([]byte(user.Password), -1)
+		if err != nil {
+			return err
+		}
+
+		user.Password = string(pass)
+	}
+
+	user.Muted = ParseTags(strings.Join(user.Muted, ","))
+	user.MutedStr = strings.Join(user.Muted, ",")
+
+	key := strings.Replace(UserKey, "{{user}}", user.Name, -1)
+	_, err := user.Do("hmset", redis.Args{}.Add(key).AddFlat(user)...)
+	return err
+}
+
+// Delete removes the user data.
+func (user *User) Delete() error {
+	_, err := user.Do("del", strings.Replace(UserKey, "{{user}}", user.Name, -1))
+
+	return err
+}
+
+/*
+  Device
+*/
+
+// Device represents a single device hash for a user.
+type Device struct {
+	*Conn `json:"-" redis:"-"`
+	Name  string `json:"name" redis:"name"`
+	Token string `json:"token" redis:"token"`
+	User  *User  `json:"-" redis:"-"`
+}
+
+// Validate ensures the data is valid, if new it'll check if it exists.
+func (device *Device) Validate(new bool) ([]string, error) {
+	return Validations(func() (error, error) {
+		if device.Name == "" {
+			return ErrDeviceNameEmpty, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if !new || device.Name == "" {
+			return nil, nil
+		}
+
+		exists, err := device.DeviceExists(device.User.Name, device.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			return ErrDeviceAlreadyExists, nil
+		}
+
+		return nil, nil
+	})
+}
+
+// Save saves the device data, generating a token if needed.
+func (device *Device) Save(genToken bool) error {
+	if genToken {
+		now := time.Now().String()
+		tok, err := uuid.NewV5(uuid.NamespaceURL, []byte(now+device.User.Name+device.Name))
+		if err != nil {
+			return err
+		}
+
+		device.Token = tok.String()
+	}
+
+	// Add to device set
+	key := strings.Replace(DevicesKey, "{{user}}", device.User.Name, -1)
+	_, err := device.Do("sadd", key, device.Name)
+	if err != nil {
+		return err
+	}
+
+	// Add device hash
+	key = strings.Replace(DeviceKey, "{{user}}", device.User.Name, -1)
+	key = strings.Replace(key, "{{device}}", device.Name, -1)
+	_, err = device.Do("hmset", redis.Args{}.Add(key).AddFlat(device)...)
+	if err != nil {
+		return err
+	}
+
+	// Add token hash
+	key = strings.Replace(TokenKey, "{{token}}", device.Token, -1)
+	_, err = device.Do("hmset", redis.Args{}.Add(key).AddFlat(&Token{device.User.Name, device.Name})...)
+	if err != nil {
+		return err
+	}
+
+	return device.publish(EventDeviceSaved)
+}
+
+// Delete removes the device data.
+func (device *Device) Delete() error {
+	// Remove token hash
+	_, err := device.Do("del", strings.Replace(TokenKey, "{{token}}", device.Token, -1))
+	if err != nil {
+		return err
+	}
+
+	// Remove device hash
+	key := strings.Replace(DeviceKey, "{{user}}", device.User.Name, -1)
+	_, err = device.Do("del", strings.Replace(key, "{{device}}", device.Name, -1))
+	if err != nil {
+		return err
+	}
+
+	// Remove from device set
+	key = strings.Replace(DevicesKey, "{{user}}", device.User.Name, -1)
+	_, err = device.Do("srem", key, device.Name)
+	if err != nil {
+		return err
+	}
+
+	return device.publish(EventDeviceDeleted)
+}
+
+// publish records the change to the device and publishes it as an event.
+func (device *Device) publish(eventType string) error {
+	err := device.RecordChange(device.User.Name, "device:"+device.Name)
+	if err != nil {
+		return err
+	}
+
+	return device.PublishEvent(device.User.Name, eventType, device.event())
+}
+
+// event gets the devices event data, events are sent to webhooks so the token isn't given.
+func (device *Device) event() map[string]string {
+	return map[string]string{"name": device.Name}
+}
+
+/*
+  Activity
+*/
+
+// Activity types, the part before the dot is the activities category.
+const (
+	ActivityAuthFailed        = "auth.failed"
+	ActivityDeviceCreated     = "device.created"
+	ActivityDeviceDeleted     = "device.deleted"
+	ActivityUserUpdated       = "user.updated"
+	ActivityTaskCreated       = "task.created"
+	ActivityTaskUpdated       = "task.updated"
+	ActivityTaskRenamed       = "task.renamed"
+	ActivityTaskCompleted     = "task.completed"
+	ActivityTaskReopened      = "task.reopened"
+	ActivityTaskDeleted       = "task.deleted"
+	ActivityTaskRestored      = "task.restored"
+	ActivityTaskPurged        = "task.purged"
+	ActivityTaskAssigned      = "task.assigned"
+	ActivityTaskReminded      = "task.reminded"
+	ActivityListMemberSet     = "list.member_set"
+	ActivityListMemberRemoved = "list.member_removed"
+	ActivityWebhookDisabled   = "webhook.disabled"
+)
+
+// ActivityTypes are the known activity types.
+var ActivityTypes = []string{ActivityAuthFailed, ActivityDeviceCreated, ActivityDeviceDeleted,
+	ActivityUserUpdated, ActivityTaskCreated, ActivityTaskUpdated, ActivityTaskRenamed,
+	ActivityTaskCompleted, ActivityTaskReopened, ActivityTaskDeleted, ActivityTaskRestored,
+	ActivityTaskPurged, ActivityTaskAssigned, ActivityTaskReminded, ActivityListMemberSet,
+	ActivityListMemberRemoved, ActivityWebhookDisabled}
+
+// Activity represents a single activity hash for a user.
+type Activity struct {
+	*Conn   `json:"-" redis:"-"`
+	ID      string            `json:"id" redis:"id"`
+	Type    string            `json:"type" redis:"type"`
+	Message string            `json:"message" redis:"message"`
+	Meta    map[string]string `json:"meta" redis:"-"`
+	MetaStr string            `json:"-" redis:"meta"`
+	Device  string            `json:"device" redis:"device"`
+	Time    string            `json:"time" redis:"time"`
+	User    *User             `json:"-" redis:"-"`
+	score   int64
+}
+
+// activityTrimBatch is the most activities removed for retention at once.
+const activityTrimBatch = 100
+
+// ActivityScore gets the score for a time in the activity index, which is in microseconds
+// so it's exact as a Redis score.
+func ActivityScore(date time.Time) int64 {
+	return date.UnixNano() / int64(time.Microsecond)
+}
+
+// ActivityCategory gets the category of an activity type.
+func ActivityCategory(activityType string) string {
+	return strings.SplitN(activityType, ".", 2)[0]
+}
+
+// ValidActivityType checks if the value is a known activity type or category.
+func ValidActivityType(value string) bool {
+	for _, activityType := range ActivityTypes {
+		if value == activityType || value == ActivityCategory(activityType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indexKeys gets the index keys the activity is in, the time index and the indexes for
+// its type and category.
+func (activity *Activity) indexKeys() []string {
+	keys := []string{strings.Replace(ActivityIndexKey, "{{user}}", activity.User.Name, -1)}
+	if activity.Type == "" {
+		return keys
+	}
+
+	key := strings.Replace(ActivityTypeKey, "{{user}}", activity.User.Name, -1)
+	keys = append(keys, strings.Replace(key, "{{type}}", activity.Type, -1))
+
+	category := ActivityCategory(activity.Type)
+	if category != activity.Type {
+		keys = append(keys, strings.Replace(key, "{{type}}", category, -1))
+	}
+
+	return keys
+}
+
+// Save saves the activity data, unless the user has muted its type.
+func (activity *Activity) Save() error {
+	id, err := activity.incr(strings.Replace(ActivityIDKey, "{{user}}", activity.User.Name, -1))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	activity.ID = strconv.Itoa(id)
+	activity.Time = now.Format(time.RFC3339)
+	activity.score = ActivityScore(now)
+
+	if activity.Meta == nil {
+		activity.Meta = make(map[string]string)
+	}
+
+	meta, err := json.Marshal(activity.Meta)
+	if err != nil {
+		return err
+	}
+	activity.MetaStr = string(meta)
+
+	// The users mutes are checked with the writes, so they apply to any user given
+	key := strings.Replace(ActivityKey, "{{user}}", activity.User.Name, -1)
+	key = strings.Replace(key, "{{activity}}", activity.ID, -1)
+	keys := []string{strings.Replace(UserKey, "{{user}}", activity.User.Name, -1),
+		strings.Replace(ActivitiesKey, "{{user}}", activity.User.Name, -1), key, ActivityUsersKey}
+	keys = append(keys, activity.indexKeys()...)
+
+	args := redis.Args{}.Add(len(keys)).AddFlat(keys).Add(activity.ID, activity.score, activity.Type,
+		ActivityCategory(activity.Type), activity.User.Name).AddFlat(activity)
+	reply, err := saveActivity.Do(activity.Conn, args...)
+	if err != nil {
+		return err
+	}
+
+	// In a transaction the reply is queued, so it's checked once the transaction is applied
+	if activity.multi {
+		activity.applied = append(activity.applied, func() error {
+			saved, err := activity.exists(key)
+			if err != nil || !saved {
+				return err
+			}
+
+			return activity.created()
+		})
+		return nil
+	}
+
+	saved, err := redis.Bool(reply, nil)
+	if err != nil || !saved {
+		return err
+	}
+
+	return activity.created()
+}
+
+// created enforces the retention and publishes a saved activity.
+func (activity *Activity) created() error {
+	_, err := activity.trimActivities(activity.User.Name, activityTrimBatch)
+	if err != nil {
+		return err
+	}
+
+	return activity.PublishEvent(activity.User.Name, EventActivityCreated, activity)
+}
+
+// Delete removes the activity data.
+func (activity *Activity) Delete() error {
+	// Unfortunately there's no easy way to remove the list item...so only use this if you plan
+	// to remove the list item yourself or deleting all the activities.
+
+	key := strings.Replace(ActivityKey, "{{user}}", activity.User.Name, -1)
+	_, err := activity.Do("del", strings.Replace(key, "{{activity}}", activity.ID, -1))
+	if err != nil {
+		return err
+	}
+
+	// Remove from the time indexes
+	for _, key = range activity.indexKeys() {
+		_, err = activity.Do("zrem", key, activity.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+  Task
+*/
+
+// taskNotesMax is the most bytes a tasks notes can contain.
+const taskNotesMax = 64 * 1024
+
+// Task represents a single task hash for a user.
+type Task struct {
+	*Conn     `json:"-" redis:"-"`
+	ID        int      `json:"id" redis:"id"`
+	Message   string   `json:"message" redis:"message"`
+	Notes     string   `json:"notes" redis:"notes"`
+	NotesHTML string   `json:"notesHtml,omitempty" redis:"-"`
+	Category  string   `json:"category" redis:"category"`
+	Complete  bool     `json:"complete" redis:"complete"`
+	Priority  string   `json:"priority" redis:"priority"`
+	Remind    string   `json:"remind" redis:"remind"`
+	TagsStr   string   `json:"-" redis:"tags"`
+	Tags      []string `json:"tags" redis:"-"`
+	Position  float64  `json:"position" redis:"-"`
+	Revision  int      `json:"revision" redis:"revision"`
+	Deleted   string   `json:"deleted,omitempty" redis:"deleted"`
+	List      string   `json:"list" redis:"list"`
+	Assignee  string   `json:"assignee" redis:"assignee"`
+	Owner     string   `json:"owner,omitempty" redis:"-"`
+	UID       string   `json:"uid,omitempty" redis:"uid"`
+	SyncRef   string   `json:"-" redis:"syncref"`
+	Created   string   `json:"created" redis:"created"`
+	Completed string   `json:"completed" redis:"completed"`
+	User      *User    `json:"-" redis:"-"`
+	Actor     *User    `json:"-" redis:"-"`
+	saved     *Task
+
+	// completeInvalid is set when complete was given as a value that isn't a boolean
+	completeInvalid bool
+
+	// unordered is set when the task isn't in its category order yet
+	unordered bool
+}
+
+// Validate ensures the data is valid.
+func (task *Task) Validate() ([]string, error) {
+	return Validations(func() (error, error) {
+		if task.Message == "" {
+			return ErrTaskMessageEmpty, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if len(task.Notes) > taskNotesMax {
+			return ErrTaskNotesTooLarge, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if task.completeInvalid {
+			return ErrTaskCompleteInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if task.Priority == "" {
+			return nil, nil
+		}
+
+		if len(task.Priority) != 1 || task.Priority[0] < 'A' || task.Priority[0] > 'Z' {
+			return ErrTaskPriorityInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if task.Remind == "" {
+			return nil, nil
+		}
+
+		_, err := time.Parse(time.RFC3339, task.Remind)
+		if err != nil {
+			return ErrTaskRemindInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if task.List == "" {
+			return nil, nil
+		}
+
+		// Tasks are stored with the list owner, so only they can have tasks in it
+		list, err := task.GetList(task.List)
+		if err != nil {
+			return nil, err
+		}
+
+		if list == nil || list.Owner != task.User.Name {
+			return ErrTaskListInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if task.Assignee == "" || task.Assignee == task.User.Name {
+			return nil, nil
+		}
+
+		// Other users can only be assigned tasks in lists they're a member of
+		if task.List == "" {
+			return ErrTaskAssigneeInvalid, nil
+		}
+
+		list, err := task.GetList(task.List)
+		if err != nil {
+			return nil, err
+		}
+
+		if list == nil || list.Role(task.Assignee) == "" {
+			return ErrTaskAssigneeInvalid, nil
+		}
+
+		return nil, nil
+	})
+}
+
+// actor gets the user making changes to the task.
+func (task *Task) actor() *User {
+	if task.Actor != nil {
+		return task.Actor
+	}
+
+	return task.User
+}
+
+// Patch applies a merge patch to the patchable task fields.
+func (task *Task) Patch(patch map[string]interface{}) []string {
+	errs := ApplyPatch("Task", patch, map[string]*PatchField{
+		"message":  PatchString(&task.Message),
+		"notes":    PatchString(&task.Notes),
+		"category": PatchString(&task.Category),
+		"complete": PatchBool(&task.Complete),
+		"priority": PatchString(&task.Priority),
+		"remind":   PatchString(&task.Remind),
+		"tags":     PatchStrings(&task.Tags),
+		"list":     PatchString(&task.List),
+		"assignee": PatchString(&task.Assignee),
+	})
+
+	task.Tags = ParseTags(strings.Join(task.Tags, ","))
+	return errs
+}
+
+// snapshot records the task as it's stored, so saving can update what's changed.
+func (task *Task) snapshot() {
+	saved := *task
+	saved.Tags = append([]string(nil), task.Tags...)
+	saved.saved = nil
+
+	task.saved = &saved
+}
+
+// change gets the changes to the task since it was last stored, if there are any.
+func (task *Task) change() *TaskChange {
+	saved := task.saved
+	if saved == nil {
+		saved = &Task{Tags: make([]string, 0)}
+	}
+	fields := make(map[string]*FieldChange)
+
+	if saved.Message != task.Message {
+		fields["message"] = &FieldChange{saved.Message, task.Message}
+	}
+	if saved.Notes != task.Notes {
+		fields["notes"] = &FieldChange{saved.Notes, task.Notes}
+	}
+	if saved.Category != task.Category {
+		fields["category"] = &FieldChange{saved.Category, task.Category}
+	}
+	if saved.Complete != task.Complete {
+		fields["complete"] = &FieldChange{saved.Complete, task.Complete}
+	}
+	if saved.Priority != task.Priority {
+		fields["priority"] = &FieldChange{saved.Priority, task.Priority}
+	}
+	if saved.Remind != task.Remind {
+		fields["remind"] = &FieldChange{saved.Remind, task.Remind}
+	}
+	if saved.List != task.List {
+		fields["list"] = &FieldChange{saved.List, task.List}
+	}
+	if saved.Assignee != task.Assignee {
+		fields["assignee"] = &FieldChange{saved.Assignee, task.Assignee}
+	}
+	if strings.Join(saved.Tags, ",") != strings.Join(task.Tags, ",") {
+		fields["tags"] = &FieldChange{saved.Tags, task.Tags}
+	}
+
+	restored := saved.Deleted != "" && task.Deleted == ""
+	if task.saved != nil && !restored && len(fields) <= 0 {
+		return nil
+	}
+
+	action := "updated"
+	switch {
+	case task.saved == nil:
+		action = "created"
+	case restored:
+		action = "restored"
+	case fields["complete"] != nil && task.Complete:
+		action = "completed"
+	case fields["complete"] != nil:
+		action = "reopened"
+	case fields["message"] != nil:
+		action = "renamed"
+	}
+
+	actor := task.actor()
+	return &TaskChange{Action: action, Revision: task.Revision, Time: time.Now().Format(time.RFC3339),
+		User: actor.Name, Device: actor.Device, Fields: fields}
+}
+
+// SearchDoc gets the search document for the task.
+func (task *Task) SearchDoc() string {
+	return "task:" + strconv.Itoa(task.ID)
+}
+
+// SearchText gets the task text that's searchable.
+func (task *Task) SearchText() []string {
+	return append([]string{task.Message, task.Notes, task.Category}, task.Tags...)
+}
+
+// CalendarUID gets the tasks iCalendar uid, tasks that weren't imported with one
+// use <user>-<task>@moln.
+func (task *Task) CalendarUID() string {
+	if task.UID != "" {
+		return task.UID
+	}
+
+	return task.User.Name + "-" + strconv.Itoa(task.ID) + CalendarUIDSuffix
+}
+
+// ref gets the <user>:<task> reference for the task used in sets shared by all users.
+func (task *Task) ref() string {
+	return task.User.Name + ":" + strconv.Itoa(task.ID)
+}
+
+// Save saves the task data, generating an id if needed.
+func (task *Task) Save(genID bool) error {
+	key := ""
+	if genID {
+		id, err := task.NextTaskID(task.User.Name)
+		if err != nil {
+			return err
+		}
+
+		task.ID = id
+	}
+	idstr := strconv.Itoa(task.ID)
+	task.TagsStr = strings.Join(task.Tags, ",")
+	err := task.incrementRevision()
+	if err != nil {
+		return err
+	}
+	change := task.change()
+
+	// Record when the task was created and completed, imported tasks may already have times. A
+	// completed time is only kept when completing a task if it was given rather than stored
+	now := time.Now().Format(time.RFC3339)
+	if task.saved == nil && task.Created == "" {
+		task.Created = now
+	}
+
+	wasOpen := task.saved != nil && !task.saved.Complete
+	if !task.Complete {
+		task.Completed = ""
+	} else if task.Completed == "" || (wasOpen && task.Completed == task.saved.Completed) {
+		task.Completed = now
+	}
+
+	// Add to tasks set
+	key = strings.Replace(TasksKey, "{{user}}", task.User.Name, -1)
+	_, err = task.Do("sadd", key, idstr)
+	if err != nil {
+		return err
+	}
+
+	// Add task hash
+	key = strings.Replace(TaskKey, "{{user}}", task.User.Name, -1)
+	key = strings.Replace(key, "{{task}}", idstr, -1)
+	// The revision is only changed by incrementing it
+	fields := redis.Args{}.AddFlat(task)
+	args := redis.Args{}.Add(key)
+	for i := 0; i < len(fields); i += 2 {
+		if fields[i] != "revision" {
+			args = args.Add(fields[i], fields[i+1])
+		}
+	}
+
+	_, err = task.Do("hmset", args...)
+	if err != nil {
+		return err
+	}
+
+	// Move the task between tag sets for tags that have changed, trashed tasks aren't in any
+	savedTags := make([]string, 0)
+	if task.saved != nil && task.saved.Deleted == "" {
+		savedTags = task.saved.Tags
+	}
+
+	for _, tag := range TagsDifference(savedTags, task.Tags) {
+		err = task.untag(tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range TagsDifference(task.Tags, savedTags) {
+		key = strings.Replace(TagKey, "{{user}}", task.User.Name, -1)
+		_, err = task.Do("sadd", strings.Replace(key, "{{tag}}", tag, -1), idstr)
+		if err != nil {
+			return err
+		}
+
+		_, err = task.Do("sadd", strings.Replace(TagsKey, "{{user}}", task.User.Name, -1), tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The task is indexed against its stored terms, since the index can't be read in a transaction
+	var previous Searchable
+	if task.saved != nil {
+		previous = task.saved
+	}
+
+	err = task.Index(task.User.Name, task, previous)
+	if err != nil {
+		return err
+	}
+
+	if change != nil {
+		err = task.record(change)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Move the task between lists
+	if task.saved != nil && task.saved.List != "" && task.saved.List != task.List {
+		key = strings.Replace(ListTasksKey, "{{list}}", task.saved.List, -1)
+		_, err = task.Do("srem", key, idstr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if task.List != "" {
+		_, err = task.Do("sadd", strings.Replace(ListTasksKey, "{{list}}", task.List, -1), idstr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Move the task between assignees, letting a new assignee know
+	if task.saved != nil && task.saved.Assignee != "" && task.saved.Assignee != task.Assignee {
+		key = strings.Replace(AssignedKey, "{{user}}", task.saved.Assignee, -1)
+		_, err = task.Do("srem", key, task.ref())
+		if err != nil {
+			return err
+		}
+	}
+
+	if task.Assignee != "" {
+		_, err = task.Do("sadd", strings.Replace(AssignedKey, "{{user}}", task.Assignee, -1), task.ref())
+		if err != nil {
+			return err
+		}
+
+		actor := task.actor()
+		if change != nil && change.Fields["assignee"] != nil && task.Assignee != actor.Name {
+			activity := &Activity{Conn: task.Conn, Type: ActivityTaskAssigned, Message: "Assigned task " +
+				idstr + " " + task.Message + " by " + actor.Name, Meta: map[string]string{"task": idstr,
+				"owner": task.User.Name, "by": actor.Name}, User: &User{Name: task.Assignee}}
+
+			err = activity.Save()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Keep the tasks place in its category, moving it to the end of a new category
+	if task.saved != nil && task.saved.Category != task.Category {
+		key = strings.Replace(CategoryKey, "{{user}}", task.User.Name, -1)
+		_, err = task.Do("zrem", strings.Replace(key, "{{category}}", task.saved.Category, -1), idstr)
+		if err != nil {
+			return err
+		}
+	}
+
+	key = strings.Replace(CategoryKey, "{{user}}", task.User.Name, -1)
+	reply, err := appendTask.Do(task, strings.Replace(key, "{{category}}", task.Category, -1), idstr)
+	if err != nil {
+		return err
+	}
+
+	// Inside a transaction the reply is only queued, so the position isn't known yet
+	position, err := redis.Float64(reply, nil)
+	if err == nil {
+		task.Position = position
+		task.unordered = false
+	}
+
+	// Schedule the reminder if it's still upcoming, past reminders have already fired
+	remind, _ := time.Parse(time.RFC3339, task.Remind)
+	if !task.Complete && remind.After(time.Now()) {
+		_, err = task.Do("zadd", RemindersKey, remind.Unix(), task.ref())
+	} else {
+		_, err = task.Do("zrem", RemindersKey, task.ref())
+	}
+	if err != nil {
+		return err
+	}
+
+	// Map an imported calendar uid to the task
+	key = strings.Replace(UIDsKey, "{{user}}", task.User.Name, -1)
+	if task.saved != nil && task.saved.UID != "" && task.saved.UID != task.UID {
+		_, err = task.Do("hdel", key, task.saved.UID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if task.UID != "" {
+		_, err = task.Do("hset", key, task.UID, idstr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Map the ref of the sync upload that created the task to it
+	if task.SyncRef != "" {
+		key = strings.Replace(SyncRefsKey, "{{user}}", task.User.Name, -1)
+		_, err = task.Do("hset", key, task.SyncRef, idstr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if change != nil {
+		err = task.publish("task." + change.Action)
+		if err != nil {
+			return err
+		}
+	}
+
+	task.snapshot()
+	return nil
+}
+
+// Delete removes the task data.
+func (task *Task) Delete() error {
+	id := strconv.Itoa(task.ID)
+
+	// Remove task hash
+	key := strings.Replace(TaskKey, "{{user}}", task.User.Name, -1)
+	_, err := task.Do("del", strings.Replace(key, "{{task}}", id, -1))
+	if err != nil {
+		return err
+	}
+
+	// Remove history
+	key = strings.Replace(HistoryKey, "{{user}}", task.User.Name, -1)
+	_, err = task.Do("del", strings.Replace(key, "{{task}}", id, -1))
+	if err != nil {
+		return err
+	}
+
+	// Remove from trash
+	_, err = task.Do("zrem", strings.Replace(TrashKey, "{{user}}", task.User.Name, -1), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = task.Do("zrem", PurgeKey, task.ref())
+	if err != nil {
+		return err
+	}
+
+	return task.unlink()
+}
+
+// Purge permanently deletes the task, recording it in the owners activities. Use Delete
+// when the deletion isn't made by a user.
+func (task *Task) Purge() error {
+	err := task.Delete()
+	if err != nil {
+		return err
+	}
+
+	actor := task.actor()
+	err = task.saveActivity(&TaskChange{Action: "purged", Time: time.Now().Format(time.RFC3339),
+		User: actor.Name, Device: actor.Device})
+	if err != nil {
+		return err
+	}
+
+	return task.publish(EventTaskPurged)
+}
+
+// Trash moves the task to the trash, it's kept until purged but only accessible from
+// the trash.
+func (task *Task) Trash() error {
+	id := strconv.Itoa(task.ID)
+	now := time.Now()
+	task.Deleted = now.Format(time.RFC3339)
+
+	// Update task hash
+	err := task.incrementRevision()
+	if err != nil {
+		return err
+	}
+
+	key := strings.Replace(TaskKey, "{{user}}", task.User.Name, -1)
+	key = strings.Replace(key, "{{task}}", id, -1)
+	_, err = task.Do("hset", key, "deleted", task.Deleted)
+	if err != nil {
+		return err
+	}
+
+	// Add to trash
+	_, err = task.Do("zadd", strings.Replace(TrashKey, "{{user}}", task.User.Name, -1), now.Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = task.Do("zadd", PurgeKey, now.Unix(), task.ref())
+	if err != nil {
+		return err
+	}
+
+	actor := task.actor()
+	err = task.record(&TaskChange{Action: "deleted", Revision: task.Revision, Time: task.Deleted,
+		User: actor.Name, Device: actor.Device, Fields: make(map[string]*FieldChange)})
+	if err != nil {
+		return err
+	}
+
+	err = task.unlink()
+	if err != nil {
+		return err
+	}
+
+	err = task.publish(EventTaskDeleted)
+	if err != nil {
+		return err
+	}
+
+	task.snapshot()
+	return nil
+}
+
+// Restore moves the task out of the trash.
+func (task *Task) Restore() error {
+	id := strconv.Itoa(task.ID)
+	task.Deleted = ""
+
+	_, err := task.Do("zrem", strings.Replace(TrashKey, "{{user}}", task.User.Name, -1), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = task.Do("zrem", PurgeKey, task.ref())
+	if err != nil {
+		return err
+	}
+
+	return task.Save(false)
+}
+
+// publish records the change to the task and publishes it as an event.
+func (task *Task) publish(eventType string) error {
+	err := task.RecordChange(task.User.Name, "task:"+strconv.Itoa(task.ID))
+	if err != nil {
+		return err
+	}
+
+	return task.PublishEvent(task.User.Name, eventType, task)
+}
+
+// record adds a change to the tasks history, keeping only the most recent.
+func (task *Task) record(change *TaskChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Replace(HistoryKey, "{{user}}", task.User.Name, -1)
+	key = strings.Replace(key, "{{task}}", strconv.Itoa(task.ID), -1)
+	_, err = task.Do("lpush", key, data)
+	if err != nil {
+		return err
+	}
+
+	if Config.HistoryMax > 0 {
+		_, err = task.Do("ltrim", key, 0, Config.HistoryMax-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return task.saveActivity(change)
+}
+
+// saveActivity records a change to the task in the owners activities, the acting device
+// is only given when the owner made the change.
+func (task *Task) saveActivity(change *TaskChange) error {
+	id := strconv.Itoa(task.ID)
+	fields := make([]string, 0, len(change.Fields))
+	for field := range change.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	activity := &Activity{Conn: task.Conn, Type: "task." + change.Action, Message: strings.Title(change.Action) +
+		" task " + id + " " + task.Message, Meta: map[string]string{"task": id, "by": change.User},
+		User: task.User}
+	if len(fields) > 0 {
+		activity.Meta["fields"] = strings.Join(fields, ",")
+	}
+	if change.User == task.User.Name {
+		activity.Device = change.Device
+	}
+
+	return activity.Save()
+}
+
+// incrementRevision increments the stored revision, so concurrent saves never store the same
+// one. In a transaction the reply is queued, so the revision the task was read at is
+// incremented instead, which is the stored revision when the task is watched.
+func (task *Task) incrementRevision() error {
+	key := strings.Replace(TaskKey, "{{user}}", task.User.Name, -1)
+	key = strings.Replace(key, "{{task}}", strconv.Itoa(task.ID), -1)
+
+	reply, err := task.Do("hincrby", key, "revision", 1)
+	if err != nil {
+		return err
+	}
+
+	if task.multi {
+		task.Revision++
+		return nil
+	}
+
+	task.Revision, err = redis.Int(reply, nil)
+	return err
+}
+
+// Move moves the task before or after another task in the same category, recording the
+// change and incrementing its revision. Inside a transaction the position is set once it's
+// applied.
+func (task *Task) Move(target *Task, after bool) error {
+	err := task.incrementRevision()
+	if err != nil {
+		return err
+	}
+
+	idstr := strconv.Itoa(task.ID)
+	key := strings.Replace(CategoryKey, "{{user}}", task.User.Name, -1)
+	key = strings.Replace(key, "{{category}}", task.Category, -1)
+
+	where := "before"
+	if after {
+		where = "after"
+	}
+
+	reply, err := moveTask.Do(task, key, strings.Replace(ChangesKey, "{{user}}", task.User.Name, -1),
+		strings.Replace(ChangeIDKey, "{{user}}", task.User.Name, -1), idstr, target.ID, where, "task:"+idstr)
+	if err != nil {
+		return err
+	}
+
+	if task.multi {
+		task.applied = append(task.applied, func() error {
+			position, err := redis.Float64(task.Do("zscore", key, idstr))
+			task.Position, task.unordered = position, false
+			return err
+		})
+		return nil
+	}
+
+	task.Position, err = redis.Float64(reply, nil)
+	task.unordered = false
+	return err
+}
+
+// untag removes the task from a tag, tags without any tasks are removed from the users tags.
+func (task *Task) untag(tag string) error {
+	key := strings.Replace(TagKey, "{{user}}", task.User.Name, -1)
+
+	_, err := untagTask.Do(task.Conn, strings.Replace(key, "{{tag}}", tag, -1),
+		strings.Replace(TagsKey, "{{user}}", task.User.Name, -1), task.ID, tag)
+	return err
+}
+
+// unlink removes the task from the task set, category order, tag sets, scheduled
+// reminders, and search index.
+func (task *Task) unlink() error {
+	id := strconv.Itoa(task.ID)
+
+	// Remove from task set
+	key := strings.Replace(TasksKey, "{{user}}", task.User.Name, -1)
+	_, err := task.Do("srem", key, id)
+	if err != nil {
+		return err
+	}
+
+	// Remove from list
+	if task.List != "" {
+		_, err = task.Do("srem", strings.Replace(ListTasksKey, "{{list}}", task.List, -1), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove from assigned tasks
+	if task.Assignee != "" {
+		_, err = task.Do("srem", strings.Replace(AssignedKey, "{{user}}", task.Assignee, -1), task.ref())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove from category order
+	key = strings.Replace(CategoryKey, "{{user}}", task.User.Name, -1)
+	_, err = task.Do("zrem", strings.Replace(key, "{{category}}", task.Category, -1), id)
+	if err != nil {
+		return err
+	}
+
+	// Remove from tag sets
+	for _, tag := range task.Tags {
+		err = task.untag(tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove scheduled reminder
+	_, err = task.Do("zrem", RemindersKey, task.ref())
+	if err != nil {
+		return err
+	}
+
+	// Remove calendar uid and sync ref
+	if task.UID != "" {
+		_, err = task.Do("hdel", strings.Replace(UIDsKey, "{{user}}", task.User.Name, -1), task.UID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if task.SyncRef != "" {
+		key := strings.Replace(SyncRefsKey, "{{user}}", task.User.Name, -1)
+		_, err = task.Do("hdel", key, task.SyncRef)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The terms are removed as they were stored
+	if task.saved != nil {
+		return task.Unindex(task.User.Name, task.saved)
+	}
+
+	return task.Unindex(task.User.Name, task)
+}
+
+// tasksByPosition sorts tasks by category and then by their position in it. Tasks that
+// aren't in the order yet come last, as they'll be added to the end when saved.
+type tasksByPosition []*Task
+
+func (tasks tasksByPosition) Len() int {
+	return len(tasks)
+}
+
+func (tasks tasksByPosition) Less(i, j int) bool {
+	if tasks[i].Category != tasks[j].Category {
+		return tasks[i].Category < tasks[j].Category
+	}
+	if tasks[i].unordered != tasks[j].unordered {
+		return !tasks[i].unordered
+	}
+	if tasks[i].Position != tasks[j].Position {
+		return tasks[i].Position < tasks[j].Position
+	}
+
+	return tasks[i].ID < tasks[j].ID
+}
+
+func (tasks tasksByPosition) Swap(i, j int) {
+	tasks[i], tasks[j] = tasks[j], tasks[i]
+}
+
+// TaskChange represents a single change to a task, and the user and device that made it.
+// Revision is the tasks revision after the change.
+type TaskChange struct {
+	Action   string                  `json:"action"`
+	Revision int                     `json:"revision"`
+	Time     string                  `json:"time"`
+	User     string                  `json:"user"`
+	Device   string                  `json:"device"`
+	Fields   map[string]*FieldChange `json:"fields"`
+}
+
+// FieldChange represents the previous and new value for a changed field.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+/*
+  Tag
+*/
+
+// Tag represents a users tag and the number of tasks that have it.
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+/*
+  List
+*/
+
+// List roles, each role can do everything the roles before it can.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// roleRanks orders the list roles.
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// List represents a named task list that can be shared with other users.
+type List struct {
+	*Conn   `json:"-" redis:"-"`
+	ID      string            `json:"id" redis:"id"`
+	Name    string            `json:"name" redis:"name"`
+	Owner   string            `json:"owner" redis:"owner"`
+	Members map[string]string `json:"members" redis:"-"`
+}
+
+// Validate ensures the data is valid.
+func (list *List) Validate() ([]string, error) {
+	return Validations(func() (error, error) {
+		if list.Name == "" {
+			return ErrListNameEmpty, nil
 		}
 
 		return nil, nil
 	})
 }
 
-// Save saves the device data, generating a token if needed.
-func (device *Device) Save(genToken bool) error {
-	if genToken {
+// Role gets the role a user has in the list, or an empty string if they don't have access.
+func (list *List) Role(user string) string {
+	if user == list.Owner {
+		return RoleOwner
+	}
+
+	return list.Members[user]
+}
+
+// Can checks if a user has at least the given role in the list.
+func (list *List) Can(user, role string) bool {
+	return roleRanks[list.Role(user)] >= roleRanks[role]
+}
+
+// Save saves the list data, generating an id if needed.
+func (list *List) Save(genID bool) error {
+	if genID {
 		now := time.Now().String()
-		tok, err := uuid.NewV5(uuid.NamespaceURL, []byte(now+device.User.Name+device.Name))
+		id, err := uuid.NewV5(uuid.NamespaceURL, []byte(now+list.Owner+list.Name))
 		if err != nil {
 			return err
 		}
 
-		device.Token = tok.String()
+		list.ID = id.String()
+		list.Members = make(map[string]string)
 	}
 
-	// Add to device set
-	key := strings.Replace(DevicesKey, "{{user}}", device.User.Name, -1)
-	_, err := device.Do("sadd", key, device.Name)
+	// Add list hash
+	key := strings.Replace(ListKey, "{{list}}", list.ID, -1)
+	_, err := list.Do("hmset", redis.Args{}.Add(key).AddFlat(list)...)
 	if err != nil {
 		return err
 	}
 
-	// Add device hash
-	key = strings.Replace(DeviceKey, "{{user}}", device.User.Name, -1)
-	key = strings.Replace(key, "{{device}}", device.Name, -1)
-	_, err = device.Do("hmset", redis.Args{}.Add(key).AddFlat(device)...)
+	// Add to owners lists
+	_, err = list.Do("sadd", strings.Replace(UserListsKey, "{{user}}", list.Owner, -1), list.ID)
+	return err
+}
+
+// SetMember adds a user to the list with the given role, or changes their role.
+func (list *List) SetMember(user, role string) error {
+	_, err := list.Do("hset", strings.Replace(MembersKey, "{{list}}", list.ID, -1), user, role)
 	if err != nil {
 		return err
 	}
+	list.Members[user] = role
 
-	// Add token hash
-	key = strings.Replace(TokenKey, "{{token}}", device.Token, -1)
-	_, err = device.Do("hmset", redis.Args{}.Add(key).AddFlat(&Token{device.User.Name, device.Name})...)
+	_, err = list.Do("sadd", strings.Replace(UserListsKey, "{{user}}", user, -1), list.ID)
 	return err
 }
 
-// Delete removes the device data.
-func (device *Device) Delete() error {
-	// Remove token hash
-	_, err := device.Do("del", strings.Replace(TokenKey, "{{token}}", device.Token, -1))
+// RemoveMember removes a user from the list, unassigning them from its tasks.
+func (list *List) RemoveMember(user string) error {
+	err := list.unassign(user)
 	if err != nil {
 		return err
 	}
 
-	// Remove device hash
-	key := strings.Replace(DeviceKey, "{{user}}", device.User.Name, -1)
-	_, err = device.Do("del", strings.Replace(key, "{{device}}", device.Name, -1))
+	_, err = list.Do("hdel", strings.Replace(MembersKey, "{{list}}", list.ID, -1), user)
 	if err != nil {
 		return err
 	}
+	delete(list.Members, user)
 
-	// Remove from device set
-	key = strings.Replace(DevicesKey, "{{user}}", device.User.Name, -1)
-	_, err = device.Do("srem", key, device.Name)
+	_, err = list.Do("srem", strings.Replace(UserListsKey, "{{user}}", user, -1), list.ID)
 	return err
 }
 
-/*
-  Activity
-*/
+// unassign unassigns a user from the tasks in the list.
+func (list *List) unassign(user string) error {
+	tasks, err := list.GetListTasks(list)
+	if err != nil {
+		return err
+	}
 
-// Activity represents a single activity hash for a user.
-type Activity struct {
-	*Conn   `json:"-" redis:"-"`
-	Message string `json:"message" redis:"message"`
-	Time    string `json:"time" redis:"time"`
-	User    *User  `json:"-" redis:"-"`
+	for _, task := range tasks {
+		if task.Assignee != user {
+			continue
+		}
+
+		err = list.updateTask(list.Owner, strconv.Itoa(task.ID), func(task *Task) bool {
+			if task.List != list.ID || task.Assignee != user {
+				return false
+			}
+
+			task.Assignee = ""
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// Save saves the activity data.
-func (activity *Activity) Save() error {
-	activity.Time = time.Now().Format(time.RFC3339)
+// Delete removes the list data, its tasks are kept as the owners own tasks.
+func (list *List) Delete() error {
+	tasks, err := list.GetListTasks(list)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		err = list.updateTask(list.Owner, strconv.Itoa(task.ID), func(task *Task) bool {
+			if task.List != list.ID {
+				return false
+			}
+			task.List = ""
+
+			// Only the owner can be assigned tasks outside a list
+			if task.Assignee != list.Owner {
+				task.Assignee = ""
+			}
+
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for member := range list.Members {
+		err = list.RemoveMember(member)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Add to activity list
-	key := strings.Replace(ActivitiesKey, "{{user}}", activity.User.Name, -1)
-	_, err := activity.Do("lpush", key, activity.Time)
+	_, err = list.Do("srem", strings.Replace(UserListsKey, "{{user}}", list.Owner, -1), list.ID)
 	if err != nil {
 		return err
 	}
 
-	// Add activity hash
-	key = strings.Replace(ActivityKey, "{{user}}", activity.User.Name, -1)
-	key = strings.Replace(key, "{{activity}}", activity.Time, -1)
-	_, err = activity.Do("hmset", redis.Args{}.Add(key).AddFlat(activity)...)
+	_, err = list.Do("del", strings.Replace(ListKey, "{{list}}", list.ID, -1),
+		strings.Replace(MembersKey, "{{list}}", list.ID, -1),
+		strings.Replace(ListTasksKey, "{{list}}", list.ID, -1))
 	return err
 }
 
-// Delete removes the activity data.
-func (activity *Activity) Delete() error {
-	// Unfortunately there's no easy way to remove the list item...so only use this if you plan
-	// to remove the list item yourself or deleting all the activities.
+/*
+  Event
+*/
 
-	key := strings.Replace(ActivityKey, "{{user}}", activity.User.Name, -1)
-	_, err = activity.Do("del", strings.Replace(key, "{{activity}}", activity.Time, -1))
-	return err
+// Event types for the resource changes given to streams, tasks use their change action.
+const (
+	EventTaskDeleted     = "task.deleted"
+	EventTaskPurged      = "task.purged"
+	EventTaskMoved       = "task.moved"
+	EventDeviceSaved     = "device.saved"
+	EventDeviceDeleted   = "device.deleted"
+	EventActivityCreated = "activity.created"
+)
+
+// EventTypes are the known event types.
+var EventTypes = []string{"task.created", "task.updated", "task.renamed", "task.completed", "task.reopened",
+	"task.restored", EventTaskDeleted, EventTaskPurged, EventTaskMoved, EventDeviceSaved, EventDeviceDeleted,
+	EventActivityCreated}
+
+// ValidEventType checks if the value is a known event type or category.
+func ValidEventType(value string) bool {
+	for _, eventType := range EventTypes {
+		if value == eventType || value == ActivityCategory(eventType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event represents a single change published to a users streams, the data is the changed
+// resource as JSON.
+type Event struct {
+	ID   int64
+	Type string
+	Data string
+}
+
+// ParseEvent parses an event as it's kept and published, in the form <id>\n<type>\n<data>.
+func ParseEvent(entry string) (*Event, error) {
+	parts := strings.SplitN(entry, "\n", 3)
+	if len(parts) != 3 {
+		return nil, ErrEventInvalid
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrEventInvalid
+	}
+
+	return &Event{id, parts[1], parts[2]}, nil
+}
+
+// String formats the event as a Server-Sent Events message.
+func (event *Event) String() string {
+	return "id: " + strconv.FormatInt(event.ID, 10) + "\nevent: " + event.Type + "\ndata: " + event.Data +
+		"\n\n"
 }
 
 /*
-  Task
+  Webhook
 */
 
-// Task represents a single task hash for a user.
-type Task struct {
-	*Conn    `json:"-" redis:"-"`
-	ID       int    `json:"id" redis:"id"`
-	Message  string `json:"message" redis:"message"`
-	Category string `json:"category" redis:"category"`
-	Complete bool   `json:"complete" redis:"complete"`
-	User     *User  `json:"-" redis:"-"`
+// webhookDeliveriesMax is the most deliveries logged for a webhook.
+const webhookDeliveriesMax = 50
+
+// Webhook represents a single webhook hash for a user. Failures is the number of failed
+// delivery attempts since the last success.
+type Webhook struct {
+	*Conn     `json:"-" redis:"-"`
+	ID        string   `json:"id" redis:"id"`
+	URL       string   `json:"url" redis:"url"`
+	Secret    string   `json:"-" redis:"secret"`
+	Events    []string `json:"events" redis:"-"`
+	EventsStr string   `json:"-" redis:"events"`
+	Disabled  bool     `json:"disabled" redis:"disabled"`
+	Failures  int      `json:"failures" redis:"failures"`
+	Created   string   `json:"created" redis:"created"`
+	User      *User    `json:"-" redis:"-"`
 }
 
 // Validate ensures the data is valid.
-func (task *Task) Validate() ([]string, error) {
+func (webhook *Webhook) Validate() ([]string, error) {
 	return Validations(func() (error, error) {
-		if task.Message == "" {
-			return ErrTaskMessageEmpty, nil
+		target, err := url.Parse(webhook.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return ErrWebhookURLInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		for _, event := range webhook.Events {
+			if !ValidEventType(event) {
+				return ErrWebhookEventsInvalid, nil
+			}
 		}
 
 		return nil, nil
 	})
 }
 
-// Save saves the task data, generating an id if needed.
-func (task *Task) Save(genID bool) error {
-	key := ""
-	if genID {
-		key = strings.Replace(TasksIDKey, "{{user}}", task.User.Name, -1)
-		id, err := redis.Int(task.Do("get", key))
-		if err != nil && err != redis.ErrNil {
-			return err
+// Matches checks if the webhook is given events of a type, webhooks without events are
+// given every event.
+func (webhook *Webhook) Matches(eventType string) bool {
+	if len(webhook.Events) <= 0 {
+		return true
+	}
+
+	for _, event := range webhook.Events {
+		if event == eventType || event == ActivityCategory(eventType) {
+			return true
 		}
+	}
+
+	return false
+}
 
-		id, err = redis.Int(task.Do("incr", key))
+// Save saves the webhook data, generating an id if needed.
+func (webhook *Webhook) Save(genID bool) error {
+	if genID {
+		now := time.Now()
+		id, err := uuid.NewV5(uuid.NamespaceURL, []byte(now.String()+webhook.User.Name+webhook.URL))
 		if err != nil {
 			return err
 		}
 
-		task.ID = id
+		webhook.ID = id.String()
+		webhook.Created = now.Format(time.RFC3339)
+	}
+	webhook.Events = ParseTags(strings.Join(webhook.Events, ","))
+	webhook.EventsStr = strings.Join(webhook.Events, ",")
+
+	// Add webhook hash, failures are counted by deliveries so only new webhooks set them
+	key := strings.Replace(WebhookKey, "{{user}}", webhook.User.Name, -1)
+	key = strings.Replace(key, "{{webhook}}", webhook.ID, -1)
+	fields := redis.Args{}.AddFlat(webhook)
+	args := redis.Args{}.Add(key)
+	for i := 0; i < len(fields); i += 2 {
+		if genID || fields[i] != "failures" {
+			args = args.Add(fields[i], fields[i+1])
+		}
 	}
-	idstr := strconv.Itoa(task.ID)
 
-	// Add to tasks set
-	key = strings.Replace(TasksKey, "{{user}}", task.User.Name, -1)
-	_, err := task.Do("sadd", key, idstr)
+	_, err := webhook.Do("hmset", args...)
 	if err != nil {
 		return err
 	}
 
-	// Add task hash
-	key = strings.Replace(TaskKey, "{{user}}", task.User.Name, -1)
-	key = strings.Replace(key, "{{task}}", idstr, -1)
-	_, err = task.Do("hmset", redis.Args{}.Add(key).AddFlat(task)...)
+	if !genID {
+		webhook.Failures, err = redis.Int(webhook.Do("hget", key, "failures"))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+	}
+
+	// Add to webhook set, and the enabled set events are queued for
+	_, err = webhook.Do("sadd", strings.Replace(WebhooksKey, "{{user}}", webhook.User.Name, -1), webhook.ID)
+	if err != nil {
+		return err
+	}
+
+	cmd := "sadd"
+	if webhook.Disabled {
+		cmd = "srem"
+	}
+
+	_, err = webhook.Do(cmd, strings.Replace(EnabledHooksKey, "{{user}}", webhook.User.Name, -1), webhook.ID)
 	return err
 }
 
-// Delete removes the task data.
-func (task *Task) Delete() error {
-	id := strconv.Itoa(task.ID)
+// ResetFailures starts counting the webhooks failed deliveries again.
+func (webhook *Webhook) ResetFailures() error {
+	key := strings.Replace(WebhookKey, "{{user}}", webhook.User.Name, -1)
+	webhook.Failures = 0
 
-	// Remove task hash
-	key := strings.Replace(TaskKey, "{{user}}", task.User.Name, -1)
-	_, err = task.Do("del", strings.Replace(key, "{{task}}", id, -1))
+	_, err := webhook.Do("hset", strings.Replace(key, "{{webhook}}", webhook.ID, -1), "failures", 0)
+	return err
+}
+
+// Delete removes the webhook data and its delivery log.
+func (webhook *Webhook) Delete() error {
+	key := strings.Replace(WebhookKey, "{{user}}", webhook.User.Name, -1)
+	_, err := webhook.Do("del", strings.Replace(key, "{{webhook}}", webhook.ID, -1))
 	if err != nil {
 		return err
 	}
 
-	// Remove from task set
-	key = strings.Replace(TasksKey, "{{user}}", task.User.Name, -1)
-	_, err = task.Do("srem", key, id)
+	key = strings.Replace(DeliveriesKey, "{{user}}", webhook.User.Name, -1)
+	_, err = webhook.Do("del", strings.Replace(key, "{{webhook}}", webhook.ID, -1))
+	if err != nil {
+		return err
+	}
+
+	_, err = webhook.Do("srem", strings.Replace(WebhooksKey, "{{user}}", webhook.User.Name, -1), webhook.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = webhook.Do("srem", strings.Replace(EnabledHooksKey, "{{user}}", webhook.User.Name, -1), webhook.ID)
 	return err
 }
 
+// Record logs a delivery and counts failed attempts, disabling the webhook once there have
+// been too many in a row.
+func (webhook *Webhook) Record(delivery *Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Replace(DeliveriesKey, "{{user}}", webhook.User.Name, -1)
+	key = strings.Replace(key, "{{webhook}}", webhook.ID, -1)
+	_, err = webhook.Do("lpush", key, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = webhook.Do("ltrim", key, 0, webhookDeliveriesMax-1)
+	if err != nil {
+		return err
+	}
+
+	key = strings.Replace(WebhookKey, "{{user}}", webhook.User.Name, -1)
+	key = strings.Replace(key, "{{webhook}}", webhook.ID, -1)
+	if delivery.Error == "" {
+		webhook.Failures = 0
+		_, err = webhook.Do("hset", key, "failures", 0)
+		return err
+	}
+
+	webhook.Failures, err = redis.Int(webhook.Do("hincrby", key, "failures", 1))
+	if err != nil {
+		return err
+	}
+
+	if Config.WebhookFailureMax <= 0 || webhook.Failures < Config.WebhookFailureMax {
+		return nil
+	}
+
+	webhook.Disabled = true
+	_, err = webhook.Do("hset", key, "disabled", webhook.Disabled)
+	if err != nil {
+		return err
+	}
+
+	_, err = webhook.Do("srem", strings.Replace(EnabledHooksKey, "{{user}}", webhook.User.Name, -1), webhook.ID)
+	if err != nil {
+		return err
+	}
+
+	activity := &Activity{Conn: webhook.Conn, Type: ActivityWebhookDisabled, Message: "Disabled webhook " +
+		webhook.URL + " after " + strconv.Itoa(webhook.Failures) + " failed deliveries",
+		Meta: map[string]string{"webhook": webhook.ID, "url": webhook.URL,
+			"failures": strconv.Itoa(webhook.Failures)}, User: webhook.User}
+	return activity.Save()
+}
+
+// Delivery represents a single attempt to deliver an event to a webhook. Status is the
+// response status, error is set if the attempt failed.
+type Delivery struct {
+	EventID  int64   `json:"eventId"`
+	Event    string  `json:"event"`
+	Attempt  int     `json:"attempt"`
+	Status   int     `json:"status"`
+	Error    string  `json:"error"`
+	Duration float64 `json:"duration"`
+	Time     string  `json:"time"`
+}
+
+// QueuedDelivery represents an event waiting to be delivered to a webhook, attempts start
+// at zero.
+type QueuedDelivery struct {
+	Item    string
+	Attempt int
+	User    string
+	Webhook string
+	Event   *Event
+}
+
+// ParseQueuedDelivery parses a delivery as it's queued, in the form
+// <attempt>\n<user>\n<webhook>\n<event>.
+func ParseQueuedDelivery(item string) (*QueuedDelivery, error) {
+	parts := strings.SplitN(item, "\n", 4)
+	if len(parts) != 4 {
+		return nil, ErrWebhookDeliveryInvalid
+	}
+
+	attempt, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, ErrWebhookDeliveryInvalid
+	}
+
+	event, err := ParseEvent(parts[3])
+	if err != nil {
+		return nil, ErrWebhookDeliveryInvalid
+	}
+
+	return &QueuedDelivery{item, attempt, parts[1], parts[2], event}, nil
+}
+
+// String formats the delivery as it's queued.
+func (queued *QueuedDelivery) String() string {
+	return strconv.Itoa(queued.Attempt) + "\n" + queued.User + "\n" + queued.Webhook + "\n" +
+		strconv.FormatInt(queued.Event.ID, 10) + "\n" + queued.Event.Type + "\n" + queued.Event.Data
+}
+
 /*
   Token
 */
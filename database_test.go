@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDB is the database used by tests, so development data isn't touched.
+const testDB = 15
+
+// testConn connects to the development Redis server, skipping the test if it isn't running.
+func testConn(t *testing.T) *Conn {
+	conn, err := redis.DialTimeout("tcp", ":6379", time.Second, time.Second, time.Second)
+	if err != nil {
+		t.Skip("Redis isn't running:", err)
+	}
+
+	_, err = conn.Do("select", testDB)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return &Conn{Conn: conn}
+}
+
+func TestMoveTaskTargetUnordered(t *testing.T) {
+	conn := testConn(t)
+	key := strings.Replace(CategoryKey, "{{user}}", "move-test", -1)
+	key = strings.Replace(key, "{{category}}", "work", -1)
+	defer conn.Do("del", key, "users:move-test:tasks:1", "users:move-test:changes", "users:move-test:changes:id")
+
+	// Task 3 was saved before categories were ordered so it isn't in the order
+	_, err := conn.Do("zadd", key, 1, "1", 2, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{Conn: conn, ID: 1, Category: "work", User: &User{Name: "move-test"}}
+	err = task.Move(&Task{ID: 3}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := redis.Strings(conn.Do("zrange", key, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(order, ",") != "2,1,3" {
+		t.Error("expected order 2,1,3, got", order)
+	}
+}
+
+func TestTasksByPositionUnordered(t *testing.T) {
+	tasks := []*Task{{ID: 1, Category: "work", unordered: true}, {ID: 2, Category: "work", Position: 2},
+		{ID: 3, Category: "work", Position: -1}}
+	sort.Sort(tasksByPosition(tasks))
+
+	if tasks[0].ID != 3 || tasks[1].ID != 2 || tasks[2].ID != 1 {
+		t.Error("expected unordered tasks last, got", tasks[0].ID, tasks[1].ID, tasks[2].ID)
+	}
+}
+
+func TestUserValidateEmail(t *testing.T) {
+	user := &User{Name: "email-test", Password: "secret", Email: "email-test@example.com"}
+
+	errs, err := user.Validate(false)
+	if err != nil || len(errs) != 0 {
+		t.Error("valid email was rejected", errs, err)
+	}
+
+	for _, email := range []string{"email-test", "Email Test <email-test@example.com>"} {
+		user.Email = email
+		errs, err = user.Validate(false)
+		if err != nil || len(errs) != 1 || errs[0] != ErrUserEmailInvalid.Error() {
+			t.Error("expected invalid email error for", email, "got", errs, err)
+		}
+	}
+}
@@ -42,7 +42,8 @@ func CreateDeviceHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	activity := &Activity{Conn: conn, Message: "Created device " + device.Name, User: user}
+	activity := &Activity{Conn: conn, Type: ActivityDeviceCreated, Message: "Created device " + device.Name,
+		Meta: map[string]string{"name": device.Name}, Device: user.Device, User: user}
 	err = activity.Save()
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
@@ -125,7 +126,8 @@ func DeleteDeviceHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	activity := &Activity{Conn: conn, Message: "Deleted device " + device.Name, User: user}
+	activity := &Activity{Conn: conn, Type: ActivityDeviceDeleted, Message: "Deleted device " + device.Name,
+		Meta: map[string]string{"name": device.Name}, Device: user.Device, User: user}
 	err = activity.Save()
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
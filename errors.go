@@ -5,15 +5,84 @@ import (
 )
 
 var (
+	ErrTransactionAborted = errors.New("Database: transaction aborted, data was modified")
+	ErrTransactionIncr    = errors.New("Database: counters can only be incremented in a transaction from a pool")
+
+	ErrPatchNotObject = errors.New("Patch: body must be a JSON object")
+
 	ErrNoAuthValue    = errors.New("Authentication: authorization header value missing")
 	ErrNoAuthPassword = errors.New("Authentication: authorization header password missing")
 
+	ErrActivityLimitInvalid  = errors.New("Activity: limit must be a number from 1 to 500")
+	ErrActivityTimeInvalid   = errors.New("Activity: before, after, and since must be RFC3339 times")
+	ErrActivitySinceAfter    = errors.New("Activity: only one of since or after can be given")
+	ErrActivityCursorInvalid = errors.New("Activity: cursor is invalid")
+
 	ErrDeviceNameEmpty     = errors.New("Device: name cannot be empty")
 	ErrDeviceAlreadyExists = errors.New("Device: name already exists")
 
 	ErrUserNameEmpty     = errors.New("User: name cannot be empty")
 	ErrUserPasswordEmpty = errors.New("User: password cannot be empty")
 	ErrUserAlreadyExists = errors.New("User: name already exists")
+	ErrUserEmailInvalid  = errors.New("User: email must be a valid address")
+	ErrUserMutedInvalid  = errors.New("User: muted must be activity types or categories")
+
+	ErrTaskMessageEmpty    = errors.New("Task: message cannot be empty")
+	ErrTaskNotesTooLarge   = errors.New("Task: notes cannot be larger than 64KB")
+	ErrTaskRemindInvalid   = errors.New("Task: remind must be an RFC3339 time")
+	ErrTaskCompleteInvalid = errors.New("Task: complete must be a boolean")
+	ErrTaskPriorityInvalid = errors.New("Task: priority must be a single letter from A to Z")
+
+	ErrTaskListInvalid     = errors.New("Task: list must be one of the users own lists")
+	ErrTaskAssigneeInvalid = errors.New("Task: assignee must be the owner or a member of the tasks list")
+
+	ErrTaskMoveTargetInvalid = errors.New("Task: either before or after must be another existing task")
+	ErrTaskMoveCategory      = errors.New("Task: can only be moved within its category")
+
+	ErrListNameEmpty      = errors.New("List: name cannot be empty")
+	ErrListRoleInvalid    = errors.New("List: role must be viewer or editor")
+	ErrListMemberNotFound = errors.New("List: member must be an existing user")
+	ErrListMemberOwner    = errors.New("List: the owner cannot be a member")
+
+	ErrBatchEmpty         = errors.New("Batch: operations cannot be empty")
+	ErrBatchTooLarge      = errors.New("Batch: too many operations")
+	ErrBatchOpInvalid     = errors.New("Batch: op must be create, update, complete, or delete")
+	ErrBatchTaskDuplicate = errors.New("Batch: a task can only be given once")
+
+	ErrImportEmpty         = errors.New("Import: no tasks were given")
+	ErrImportTooLarge      = errors.New("Import: too many tasks")
+	ErrImportTaskDuplicate = errors.New("Import: a uid can only be given once")
+
+	ErrContentTypeUnsupported = errors.New("ContentType: the response can't be given in the requested format")
+
+	ErrStatsDaysInvalid = errors.New("Stats: days must be a number from 1 to 365")
+
+	ErrEventInvalid     = errors.New("Event: invalid event")
+	ErrEventIDInvalid   = errors.New("Event: Last-Event-ID must be a positive number")
+	ErrEventUnsupported = errors.New("Event: the connection can't be streamed")
+
+	ErrSocketCommandInvalid = errors.New("Socket: command must be a JSON object")
+	ErrSocketOpInvalid      = errors.New("Socket: op must be create, update, or delete")
+	ErrSocketDataInvalid    = errors.New("Socket: data must be an object of strings, numbers, or booleans")
+
+	ErrWebhookURLInvalid      = errors.New("Webhook: url must be an http or https URL")
+	ErrWebhookEventsInvalid   = errors.New("Webhook: events must be event types or categories")
+	ErrWebhookDeliveryInvalid = errors.New("Webhook: invalid queued delivery")
+	ErrWebhookAddressPrivate  = errors.New("Webhook: url must not resolve to a private address")
+	ErrWebhookStatus          = errors.New("Webhook: responded with a non 2xx status")
+
+	ErrSyncCursorInvalid   = errors.New("Sync: cursor is invalid, sync without a cursor to start again")
+	ErrSyncLimitInvalid    = errors.New("Sync: limit must be a number from 1 to 1000")
+	ErrSyncEmpty           = errors.New("Sync: changes cannot be empty")
+	ErrSyncTooLarge        = errors.New("Sync: too many changes")
+	ErrSyncOpInvalid       = errors.New("Sync: op must be create, update, or delete")
+	ErrSyncRevisionInvalid = errors.New("Sync: revision must be a revision of the task")
+
+	ErrTagNameInvalid = errors.New("Tag: name must be a single non-empty tag")
+
+	ErrSearchQueryEmpty = errors.New("Search: query cannot be empty")
 
-	ErrTaskMessageEmpty = errors.New("Task: message cannot be empty")
+	ErrNotifierUnknown       = errors.New("Notifier: unknown notifier")
+	ErrNotifierNoSMTP        = errors.New("Notifier: email requires smtp configuration")
+	ErrNotifierWebhookStatus = errors.New("Notifier: webhook responded with a non 2xx status")
 )
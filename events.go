@@ -0,0 +1,301 @@
+package main
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/larzconwell/httpextra"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventBuffer is the most events waiting to be written to a stream, streams that fall
+// further behind are closed so the client resumes from the kept events.
+const eventBuffer = 64
+
+// eventRetry is how long the hub waits before resubscribing after losing its connection.
+const eventRetry = time.Second
+
+func init() {
+	getEvents := &Route{"GetEvents", "/events", []string{"GET"}, GetEventsHandler}
+
+	Routes = append(Routes, getEvents)
+}
+
+// EventHub receives the events published by every process and delivers them to the
+// streams open in this one, using a single subscription.
+type EventHub struct {
+	Logger  *log.Logger
+	streams map[string]map[chan *Event]bool
+	conn    redis.Conn
+	stopped bool
+	mutex   sync.Mutex
+}
+
+// NewEventHub creates a hub that logs subscription errors to the given logger.
+func NewEventHub(logger *log.Logger) *EventHub {
+	return &EventHub{Logger: logger, streams: make(map[string]map[chan *Event]bool)}
+}
+
+// Subscribe opens a stream for a users events.
+func (hub *EventHub) Subscribe(user string) chan *Event {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	stream := make(chan *Event, eventBuffer)
+	if hub.streams[user] == nil {
+		hub.streams[user] = make(map[chan *Event]bool)
+	}
+	hub.streams[user][stream] = true
+
+	return stream
+}
+
+// Unsubscribe closes a users stream if it's still open.
+func (hub *EventHub) Unsubscribe(user string, stream chan *Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.remove(user, stream)
+}
+
+// Start subscribes to every users channel in the background until stopped.
+func (hub *EventHub) Start() {
+	go func() {
+		for {
+			err := hub.run()
+
+			hub.mutex.Lock()
+			stopped := hub.stopped
+			hub.mutex.Unlock()
+			if stopped {
+				return
+			}
+
+			hub.Logger.Println(err)
+			time.Sleep(eventRetry)
+		}
+	}()
+}
+
+// Stop stops receiving events and closes the open streams.
+func (hub *EventHub) Stop() {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.stopped = true
+	if hub.conn != nil {
+		hub.conn.Close()
+	}
+	hub.closeAll()
+}
+
+// run receives events until the subscription fails. Events published while resubscribing
+// are missed, so the streams are closed and the clients resume from the kept events.
+func (hub *EventHub) run() error {
+	// The subscription waits for events indefinitely so it has no read timeout
+	conn, err := redis.DialTimeout(Config.DBNetwork, Config.DBAddr, Config.DBMaxTimeout, 0,
+		Config.DBMaxTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	hub.mutex.Lock()
+	if hub.stopped {
+		hub.mutex.Unlock()
+		return nil
+	}
+	hub.conn = conn
+	hub.mutex.Unlock()
+
+	defer func() {
+		hub.mutex.Lock()
+		hub.conn = nil
+		hub.closeAll()
+		hub.mutex.Unlock()
+	}()
+
+	psc := redis.PubSubConn{Conn: conn}
+	err = psc.PSubscribe(strings.Replace(EventsChannel, "{{user}}", "*", -1))
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.Replace(EventsChannel, "{{user}}", "", -1)
+	for {
+		switch reply := psc.Receive().(type) {
+		case redis.PMessage:
+			event, err := ParseEvent(string(reply.Data))
+			if err != nil {
+				hub.Logger.Println(err)
+				continue
+			}
+
+			hub.deliver(strings.TrimPrefix(reply.Channel, prefix), event)
+		case error:
+			return reply
+		}
+	}
+}
+
+// deliver sends an event to a users streams, closing streams that are too far behind.
+func (hub *EventHub) deliver(user string, event *Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for stream := range hub.streams[user] {
+		select {
+		case stream <- event:
+		default:
+			hub.remove(user, stream)
+		}
+	}
+}
+
+// remove closes and removes a stream, the mutex must be held.
+func (hub *EventHub) remove(user string, stream chan *Event) {
+	if !hub.streams[user][stream] {
+		return
+	}
+
+	close(stream)
+	delete(hub.streams[user], stream)
+	if len(hub.streams[user]) <= 0 {
+		delete(hub.streams, user)
+	}
+}
+
+// closeAll closes every stream, the mutex must be held.
+func (hub *EventHub) closeAll() {
+	for user, streams := range hub.streams {
+		for stream := range streams {
+			close(stream)
+		}
+		delete(hub.streams, user)
+	}
+}
+
+// openEventStream subscribes to a users events and gets the events missed since the
+// Last-Event-ID header or lastEventId query item, responding if it fails. Reset is true if
+// some of the missed events are no longer kept, so the client should get everything again.
+func openEventStream(conn *Conn, rw http.ResponseWriter, req *http.Request, user *User) (chan *Event,
+	[]*Event, bool, bool) {
+	var lastID int64
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = req.URL.Query().Get("lastEventId")
+	}
+	if lastEventID != "" {
+		var err error
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastID < 0 {
+			HandleValidations(rw, req, []string{ErrEventIDInvalid.Error()}, nil)
+			return nil, nil, false, false
+		}
+	}
+
+	// Subscribe before getting the missed events so none are lost in between
+	stream := Events.Subscribe(user.Name)
+	if lastID <= 0 {
+		return stream, make([]*Event, 0), false, true
+	}
+
+	missed, complete, err := conn.GetEvents(user.Name, lastID)
+	if err != nil {
+		Events.Unsubscribe(user.Name, stream)
+		res := &httpextra.Response{ContentTypes, rw, req}
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil, nil, false, false
+	}
+
+	return stream, missed, !complete, true
+}
+
+func GetEventsHandler(rw http.ResponseWriter, req *http.Request) {
+	queryToken(req)
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		res.Send(map[string]string{"error": ErrEventUnsupported.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	stream, missed, reset, ok := openEventStream(conn, rw, req, user)
+	if !ok {
+		return
+	}
+	defer Events.Unsubscribe(user.Name, stream)
+
+	// The connection isn't needed while streaming
+	conn.Close()
+
+	// Streams outlive the server write timeout, if it can't be lifted the stream ends before
+	// it and the client reconnects
+	var timeout <-chan time.Time
+	err := http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	if err != nil && Config.ServerMaxTimeout > 0 {
+		timeout = time.After(Config.ServerMaxTimeout - Config.ServerMaxTimeout/10)
+	}
+
+	var heartbeat <-chan time.Time
+	if Config.EventHeartbeat > 0 {
+		ticker := time.NewTicker(Config.EventHeartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	out := ""
+	if reset {
+		out += "event: reset\ndata: {}\n\n"
+	}
+	var lastID int64
+	for _, event := range missed {
+		out += event.String()
+		lastID = event.ID
+	}
+
+	for {
+		if out != "" {
+			_, err = rw.Write([]byte(out))
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			out = ""
+		}
+
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+
+			// Events may have been given as missed events already
+			if event.ID > lastID {
+				out = event.String()
+				lastID = event.ID
+			}
+		case <-heartbeat:
+			out = ": heartbeat\n\n"
+		case <-timeout:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
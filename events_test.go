@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseEvent(t *testing.T) {
+	event, err := ParseEvent("12\ntask.created\n{\"id\":1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.ID != 12 || event.Type != "task.created" || event.Data != "{\"id\":1}" {
+		t.Error("event was parsed incorrectly", event)
+	}
+
+	expected := "id: 12\nevent: task.created\ndata: {\"id\":1}\n\n"
+	if event.String() != expected {
+		t.Error("expected", expected, "got", event.String())
+	}
+
+	_, err = ParseEvent("task.created\n{}")
+	if err != ErrEventInvalid {
+		t.Error("expected an invalid event error, got", err)
+	}
+}
+
+func TestEventHubDeliver(t *testing.T) {
+	hub := NewEventHub(nil)
+	stream := hub.Subscribe("larz")
+	other := hub.Subscribe("other")
+
+	hub.deliver("larz", &Event{ID: 1})
+	if event := <-stream; event.ID != 1 {
+		t.Error("expected event 1, got", event.ID)
+	}
+	if len(other) != 0 {
+		t.Error("event was delivered to another user")
+	}
+
+	// Streams that fall behind are closed
+	for i := 0; i <= eventBuffer; i++ {
+		hub.deliver("larz", &Event{ID: int64(i)})
+	}
+	for range stream {
+	}
+	if hub.streams["larz"] != nil {
+		t.Error("stream wasn't removed")
+	}
+
+	hub.Unsubscribe("other", other)
+	if _, ok := <-other; ok {
+		t.Error("stream wasn't closed")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media types for activity exports.
+const (
+	CSVType    = "text/csv"
+	NDJSONType = "application/x-ndjson"
+)
+
+// activityExportBatch is the most activities held at once while exporting.
+const activityExportBatch = 200
+
+// activityColumns are the CSV columns for activities, meta is JSON encoded.
+var activityColumns = []string{"id", "type", "message", "meta", "device", "time"}
+
+// activityRecord gets the CSV record for an activity.
+func activityRecord(activity *Activity) ([]string, error) {
+	meta, err := json.Marshal(activity.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{activity.ID, activity.Type, activity.Message, string(meta), activity.Device,
+		activity.Time}, nil
+}
+
+// MarshalCSV marshals activities to CSV with a header row, errors are written as text.
+func MarshalCSV(data interface{}) ([]byte, error) {
+	var activities []*Activity
+
+	switch data := data.(type) {
+	case *Activity:
+		activities = []*Activity{data}
+	case []*Activity:
+		activities = data
+	default:
+		return marshalText(data)
+	}
+
+	out := new(strings.Builder)
+	writer := csv.NewWriter(out)
+	writer.Write(activityColumns)
+
+	for _, activity := range activities {
+		record, err := activityRecord(activity)
+		if err != nil {
+			return nil, err
+		}
+
+		writer.Write(record)
+	}
+
+	writer.Flush()
+	return []byte(out.String()), writer.Error()
+}
+
+// MarshalNDJSON marshals slices as a JSON value per line, other values are a single line.
+func MarshalNDJSON(data interface{}) ([]byte, error) {
+	value := reflect.ValueOf(data)
+	if value.Kind() != reflect.Slice {
+		out, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(out, '\n'), nil
+	}
+
+	out := make([]byte, 0)
+	for i := 0; i < value.Len(); i++ {
+		line, err := json.Marshal(value.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(append(out, line...), '\n')
+	}
+
+	return out, nil
+}
+
+// responseType gets the media type a response is given as, from the path extension or
+// the supported Accept value with the highest quality, the first if several have it.
+func responseType(req *http.Request) string {
+	if ext := mux.Vars(req)["ext"]; ext != "" {
+		for _, contentType := range ContentTypes {
+			if contentType.Extension == ext {
+				return contentType.Type
+			}
+		}
+	}
+
+	best := "application/json"
+	bestQuality := 0.0
+	for _, item := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(item)
+		if err != nil {
+			continue
+		}
+		if _, ok := ContentTypes[mediaType]; !ok {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if quality > bestQuality {
+			best = mediaType
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+// exportActivities writes a users activities in a score range as CSV or NDJSON, most recent
+// first. Activities are got in batches and written as they're got so the whole log isn't held
+// in memory. The response has been started, so errors can only end it early.
+func exportActivities(conn *Conn, rw http.ResponseWriter, user *User, mediaType, activityType,
+	min, max string, cursor *ActivityCursor) error {
+	var write func(activity *Activity) error
+	flush := func() error { return nil }
+
+	switch mediaType {
+	case CSVType:
+		writer := csv.NewWriter(rw)
+		write = func(activity *Activity) error {
+			record, err := activityRecord(activity)
+			if err != nil {
+				return err
+			}
+
+			return writer.Write(record)
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+
+		defer writer.Flush()
+		writer.Write(activityColumns)
+	case NDJSONType:
+		encoder := json.NewEncoder(rw)
+		write = func(activity *Activity) error {
+			return encoder.Encode(activity)
+		}
+	default:
+		return ErrContentTypeUnsupported
+	}
+
+	// Exports outlive the server write timeout, if it can't be lifted they end at it
+	http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	flusher, _ := rw.(http.Flusher)
+
+	offset := 0
+	if cursor != nil {
+		offset = cursor.Skip
+	}
+
+	for {
+		activities, err := conn.GetActivitiesRange(user.Name, activityType, min, max, offset,
+			activityExportBatch)
+		if err != nil || len(activities) <= 0 {
+			return err
+		}
+
+		for _, activity := range activities {
+			err = write(activity)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = flush()
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		// Continue after the batch like the next page would
+		cursor = NextActivityCursor(activities, cursor)
+		max = strconv.FormatInt(cursor.Score, 10)
+		offset = cursor.Skip
+	}
+}
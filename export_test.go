@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/larzconwell/httpextra"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMarshalActivities(t *testing.T) {
+	activities := []*Activity{
+		{ID: "2", Type: "task.renamed", Message: "Renamed \"a, b\"", Meta: map[string]string{"task": "1"},
+			Time: "2026-10-19T00:00:00Z"},
+		{ID: "1", Type: "device.created", Message: "Created", Device: "phone", Time: "2026-10-18T00:00:00Z"},
+	}
+
+	out, err := MarshalCSV(activities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "id,type,message,meta,device,time\n" +
+		"2,task.renamed,\"Renamed \"\"a, b\"\"\",\"{\"\"task\"\":\"\"1\"\"}\",,2026-10-19T00:00:00Z\n" +
+		"1,device.created,Created,null,phone,2026-10-18T00:00:00Z\n"
+	if string(out) != expected {
+		t.Error("expected", expected, "got", string(out))
+	}
+
+	out, err = MarshalNDJSON(activities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "{\"id\":\"2\",\"type\":\"task.renamed\",\"message\":\"Renamed \\\"a, b\\\"\",\"meta\":{\"task\":\"1\"}," +
+		"\"device\":\"\",\"time\":\"2026-10-19T00:00:00Z\"}\n" +
+		"{\"id\":\"1\",\"type\":\"device.created\",\"message\":\"Created\",\"meta\":null,\"device\":\"phone\"," +
+		"\"time\":\"2026-10-18T00:00:00Z\"}\n"
+	if string(out) != expected {
+		t.Error("expected", expected, "got", string(out))
+	}
+
+	out, err = MarshalCSV(map[string]string{"error": "Not Found"})
+	if err != nil || string(out) != "error: Not Found\n" {
+		t.Error("expected errors as text, got", string(out), err)
+	}
+}
+
+func TestResponseType(t *testing.T) {
+	for _, mediaType := range []string{CSVType, NDJSONType, "application/json"} {
+		if _, ok := ContentTypes[mediaType]; !ok {
+			ContentTypes[mediaType] = &httpextra.ContentType{Type: mediaType}
+			defer delete(ContentTypes, mediaType)
+		}
+	}
+
+	for accept, expected := range map[string]string{
+		"":                                     "application/json",
+		"text/csv;q=0.5, application/x-ndjson": NDJSONType,
+		"text/csv, application/x-ndjson;q=0.9": CSVType,
+		"text/csv;q=0, text/html":              "application/json",
+	} {
+		req := httptest.NewRequest("GET", "/activities", nil)
+		req.Header.Set("Accept", accept)
+
+		if mediaType := responseType(req); mediaType != expected {
+			t.Error("expected", expected, "for", accept, "got", mediaType)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// CalendarUIDSuffix is the domain part of the uids generated for tasks.
+const CalendarUIDSuffix = "@moln"
+
+// Layouts for iCalendar date-time and date values.
+const (
+	icalTime      = "20060102T150405Z"
+	icalLocalTime = "20060102T150405"
+	icalDate      = "20060102"
+)
+
+// icalLineMax is the most octets in a line before it's folded.
+const icalLineMax = 75
+
+// MarshalICal marshals tasks to an iCalendar feed of VTODO components, errors are
+// written as text.
+func MarshalICal(data interface{}) ([]byte, error) {
+	switch data := data.(type) {
+	case *Task:
+		return []byte(FormatICal([]*Task{data}, time.Now())), nil
+	case []*Task:
+		return []byte(FormatICal(data, time.Now())), nil
+	}
+
+	return marshalText(data)
+}
+
+// FormatICal formats tasks as an iCalendar feed. The message is the summary, the notes
+// are the description, the category is given in categories, and the reminder is the
+// due time.
+func FormatICal(tasks []*Task, now time.Time) string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//moln//moln//EN"}
+	stamp := now.UTC().Format(icalTime)
+
+	for _, task := range tasks {
+		lines = append(lines, "BEGIN:VTODO", "UID:"+icalEscape(task.CalendarUID()), "DTSTAMP:"+stamp,
+			"SUMMARY:"+icalEscape(task.Message))
+
+		if task.Notes != "" {
+			lines = append(lines, "DESCRIPTION:"+icalEscape(task.Notes))
+		}
+
+		if task.Category != "" {
+			lines = append(lines, "CATEGORIES:"+icalEscape(task.Category))
+		}
+
+		if task.Complete {
+			lines = append(lines, "STATUS:COMPLETED")
+		} else {
+			lines = append(lines, "STATUS:NEEDS-ACTION")
+		}
+
+		remind, err := time.Parse(time.RFC3339, task.Remind)
+		if err == nil {
+			lines = append(lines, "DUE:"+remind.UTC().Format(icalTime))
+		}
+
+		created, err := time.Parse(time.RFC3339, task.Created)
+		if err == nil {
+			lines = append(lines, "CREATED:"+created.UTC().Format(icalTime))
+		}
+
+		completed, err := time.Parse(time.RFC3339, task.Completed)
+		if err == nil && task.Complete {
+			lines = append(lines, "COMPLETED:"+completed.UTC().Format(icalTime))
+		}
+
+		lines = append(lines, "END:VTODO")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	out := ""
+	for _, line := range lines {
+		out += icalFold(line)
+	}
+
+	return out
+}
+
+// ReadICal reads the VTODO components in an iCalendar file as tasks, other
+// components are skipped. Only the first category is used.
+func ReadICal(r io.Reader) ([]*Task, error) {
+	lines, err := icalUnfold(r)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]*Task, 0)
+	var task *Task
+
+	for _, line := range lines {
+		name, params, value := icalProperty(line)
+
+		switch {
+		case name == "BEGIN" && value == "VTODO":
+			task = &Task{Tags: make([]string, 0)}
+		case name == "END" && value == "VTODO" && task != nil:
+			tasks = append(tasks, task)
+			task = nil
+		case task == nil:
+			continue
+		case name == "UID":
+			task.UID = icalUnescape(value)
+		case name == "SUMMARY":
+			task.Message = icalUnescape(value)
+		case name == "DESCRIPTION":
+			task.Notes = icalUnescape(value)
+		case name == "CATEGORIES" && task.Category == "":
+			task.Category = icalUnescape(icalSplit(value)[0])
+		case name == "STATUS":
+			task.Complete = value == "COMPLETED"
+		case name == "COMPLETED":
+			task.Complete = true
+			task.Completed = icalParseTime(params, value)
+		case name == "CREATED":
+			task.Created = icalParseTime(params, value)
+		case name == "DUE":
+			task.Remind = icalParseTime(params, value)
+		}
+	}
+
+	return tasks, nil
+}
+
+// icalParseTime parses a date-time or date value as an RFC3339 time, invalid
+// values are kept so validation fails.
+func icalParseTime(params map[string]string, value string) string {
+	loc := time.UTC
+	if tzid, ok := params["TZID"]; ok {
+		tz, err := time.LoadLocation(tzid)
+		if err == nil {
+			loc = tz
+		}
+	}
+
+	for _, layout := range []string{icalTime, icalLocalTime, icalDate} {
+		date, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return date.Format(time.RFC3339)
+		}
+	}
+
+	return value
+}
+
+// icalProperty splits a content line into its name, parameters, and value.
+func icalProperty(line string) (string, map[string]string, string) {
+	params := make(map[string]string)
+
+	// The value starts at the first colon that isn't in a quoted parameter
+	quoted := false
+	i := 0
+	for ; i < len(line); i++ {
+		if line[i] == '"' {
+			quoted = !quoted
+		}
+		if line[i] == ':' && !quoted {
+			break
+		}
+	}
+	if i >= len(line) {
+		return strings.ToUpper(line), params, ""
+	}
+
+	parts := strings.Split(line[:i], ";")
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], "\"")
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, line[i+1:]
+}
+
+// icalUnfold reads content lines, joining lines that were folded.
+func icalUnfold(r io.Reader) ([]string, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// icalFold folds a content line so no line is longer than icalLineMax octets,
+// without splitting multi-byte characters.
+func icalFold(line string) string {
+	out := ""
+	max := icalLineMax
+
+	for len(line) > max {
+		i := max
+		for i > 0 && line[i]&0xc0 == 0x80 {
+			i--
+		}
+
+		out += line[:i] + "\r\n "
+		line = line[i:]
+		max = icalLineMax - 1
+	}
+
+	return out + line + "\r\n"
+}
+
+// icalSplit splits a list value on commas that aren't escaped.
+func icalSplit(value string) []string {
+	values := make([]string, 0)
+	start := 0
+
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+			continue
+		}
+
+		if value[i] == ',' {
+			values = append(values, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(values, value[start:])
+}
+
+// Line breaks of any kind are escaped, a bare CR would otherwise end the content line.
+var icalEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\r", "\\n",
+	"\n", "\\n")
+
+var icalUnescaper = strings.NewReplacer("\\\\", "\\", "\\;", ";", "\\,", ",", "\\n", "\n",
+	"\\N", "\n")
+
+// icalEscape escapes a text value.
+func icalEscape(value string) string {
+	return icalEscaper.Replace(value)
+}
+
+// icalUnescape unescapes a text value.
+func icalUnescape(value string) string {
+	return icalUnescaper.Replace(value)
+}
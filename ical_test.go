@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatICal(t *testing.T) {
+	user := &User{Name: "larz"}
+	tasks := []*Task{
+		{ID: 4, Message: "Buy milk, bread", Category: "home", Remind: "2026-10-20T09:30:00+02:00", User: user},
+		{ID: 5, Message: "Call mom", Complete: true, UID: "abc@example.com", User: user},
+	}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	out := FormatICal(tasks, now)
+	for _, line := range []string{
+		"UID:larz-4@moln\r\n",
+		"SUMMARY:Buy milk\\, bread\r\n",
+		"CATEGORIES:home\r\n",
+		"DUE:20261020T073000Z\r\n",
+		"UID:abc@example.com\r\n",
+		"STATUS:COMPLETED\r\n",
+		"DTSTAMP:20261019T120000Z\r\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Error("feed is missing", strings.TrimSpace(line))
+		}
+	}
+
+	parsed, err := ReadICal(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed) != 2 {
+		t.Fatal("expected 2 tasks, got", len(parsed))
+	}
+	if parsed[0].UID != "larz-4@moln" || parsed[0].Message != "Buy milk, bread" ||
+		parsed[0].Category != "home" || parsed[0].Remind != "2026-10-20T07:30:00Z" {
+		t.Error("task did not round trip", parsed[0])
+	}
+	if parsed[1].UID != "abc@example.com" || !parsed[1].Complete {
+		t.Error("task did not round trip", parsed[1])
+	}
+}
+
+func TestReadICalFolded(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Skipped\r\nEND:VEVENT\r\n" +
+		"BEGIN:VTODO\r\nUID:1\r\nSUMMARY:A very long\r\n  summary\r\n" +
+		"DUE;TZID=\"UTC\":20261020T090000\r\nCATEGORIES:work,play\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+
+	tasks, err := ReadICal(strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tasks) != 1 {
+		t.Fatal("expected 1 task, got", len(tasks))
+	}
+	if tasks[0].Message != "A very long summary" {
+		t.Error("folded summary was not unfolded, got", tasks[0].Message)
+	}
+	if tasks[0].Remind != "2026-10-20T09:00:00Z" {
+		t.Error("due time was not parsed, got", tasks[0].Remind)
+	}
+	if tasks[0].Category != "work" {
+		t.Error("first category was not used, got", tasks[0].Category)
+	}
+}
+
+func TestICalFold(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 60)
+
+	for _, folded := range strings.Split(strings.TrimSuffix(icalFold(line), "\r\n"), "\r\n") {
+		if len(folded) > icalLineMax {
+			t.Error("line is longer than", icalLineMax, "octets")
+		}
+	}
+}
+
+func TestICalEscape(t *testing.T) {
+	escaped := icalEscape("milk;\r\nbread,\reggs\n")
+
+	if escaped != "milk\\;\\nbread\\,\\neggs\\n" {
+		t.Error("unexpected escaped value", escaped)
+	}
+}
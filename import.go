@@ -0,0 +1,193 @@
+package main
+
+import (
+	"github.com/larzconwell/httpextra"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// importMax is the most tasks an import can contain.
+const importMax = 1000
+
+// importBodyMax is the most bytes in an import, imported tasks are rarely near the notes limit so
+// it's below importMax of the largest tasks.
+const importBodyMax = 16 * 1024 * 1024
+
+func init() {
+	importTasks := &Route{"ImportTasks", "/tasks/import", []string{"POST"}, ImportTasksHandler}
+
+	Routes = append(Routes, importTasks)
+}
+
+// importICal matches the tasks from an iCalendar import to existing tasks by uid,
+// returning which tasks need to be created.
+func importICal(conn *Conn, user *User, tasks []*Task) ([]bool, []string, error) {
+	create := make([]bool, len(tasks))
+	seen := make(map[string]bool)
+	var errs []string
+
+	for i, task := range tasks {
+		if task.UID == "" {
+			create[i] = true
+			continue
+		}
+
+		if seen[task.UID] {
+			errs = append(errs, "Task "+strconv.Itoa(i+1)+": "+ErrImportTaskDuplicate.Error())
+			continue
+		}
+		seen[task.UID] = true
+
+		existing, err := conn.GetTaskByUID(user.Name, task.UID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if existing == nil {
+			create[i] = true
+			continue
+		}
+
+		// The task is watched and got again so the import fails if it changes before it's applied
+		id := strconv.Itoa(existing.ID)
+		err = conn.WatchTask(user.Name, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		existing, err = conn.GetTask(user.Name, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if existing == nil {
+			create[i] = true
+			continue
+		}
+
+		existing.Message = task.Message
+		existing.Notes = task.Notes
+		existing.Category = task.Category
+		existing.Complete = task.Complete
+		existing.Remind = task.Remind
+		if task.Completed != "" {
+			existing.Completed = task.Completed
+		}
+		tasks[i] = existing
+	}
+
+	return create, errs, nil
+}
+
+func ImportTasksHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	var (
+		tasks []*Task
+		err   error
+	)
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	calendar := mediaType == "text/calendar"
+
+	body := http.MaxBytesReader(rw, req.Body, importBodyMax)
+	if calendar {
+		tasks, err = ReadICal(body)
+	} else {
+		tasks, err = ReadTodo(body)
+	}
+	if bodyTooLarge(err) {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusRequestEntityTooLarge)},
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if len(tasks) <= 0 {
+		HandleValidations(rw, req, []string{ErrImportEmpty.Error()}, nil)
+		return
+	}
+	if len(tasks) > importMax {
+		HandleValidations(rw, req, []string{ErrImportTooLarge.Error()}, nil)
+		return
+	}
+
+	var importErrs []string
+	create := make([]bool, len(tasks))
+	for i := range create {
+		create[i] = true
+	}
+
+	if calendar {
+		create, importErrs, err = importICal(conn, user, tasks)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Validate every task first, nothing is imported unless they're all valid
+	for i, task := range tasks {
+		task.Conn = conn
+		task.User = user
+
+		errs, err := task.Validate()
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+
+		for _, e := range errs {
+			importErrs = append(importErrs, strings.Replace(e, "Task:", "Task "+strconv.Itoa(i+1)+":", 1))
+		}
+	}
+
+	ok := HandleValidations(rw, req, importErrs, nil)
+	if !ok {
+		return
+	}
+
+	// Ids can't be generated inside the transaction since replies are queued
+	for i, task := range tasks {
+		if !create[i] {
+			continue
+		}
+
+		task.ID, err = conn.NextTaskID(user.Name)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = conn.Transaction(func() error {
+		for _, task := range tasks {
+			err := task.Save(false)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err == ErrTransactionAborted {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusConflict)}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(tasks, http.StatusOK)
+}
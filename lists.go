@@ -0,0 +1,526 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/larzconwell/httpextra"
+	"net/http"
+)
+
+func init() {
+	createList := &Route{"CreateList", "/lists", []string{"POST"}, CreateListHandler}
+	getLists := &Route{"GetLists", "/lists", []string{"GET"}, GetListsHandler}
+	getList := &Route{"GetList", "/lists/{list}", []string{"GET"}, GetListHandler}
+	deleteList := &Route{"DeleteList", "/lists/{list}", []string{"DELETE"}, DeleteListHandler}
+	setMember := &Route{"SetListMember", "/lists/{list}/members/{member}", []string{"PUT"},
+		SetListMemberHandler}
+	deleteMember := &Route{"DeleteListMember", "/lists/{list}/members/{member}", []string{"DELETE"},
+		DeleteListMemberHandler}
+	createTask := &Route{"CreateListTask", "/lists/{list}/tasks", []string{"POST"}, CreateListTaskHandler}
+	getTasks := &Route{"GetListTasks", "/lists/{list}/tasks", []string{"GET"}, GetListTasksHandler}
+	getTask := &Route{"GetListTask", "/lists/{list}/tasks/{id}", []string{"GET"}, GetListTaskHandler}
+	updateTask := &Route{"UpdateListTask", "/lists/{list}/tasks/{id}", []string{"PUT"}, UpdateListTaskHandler}
+	deleteTask := &Route{"DeleteListTask", "/lists/{list}/tasks/{id}", []string{"DELETE"},
+		DeleteListTaskHandler}
+
+	Routes = append(Routes, createList, getLists, getList, deleteList, setMember, deleteMember,
+		createTask, getTasks, getTask, updateTask, deleteTask)
+}
+
+// authorizeList gets a list, responding if it doesn't exist or the user doesn't have at least
+// the given role. Lists the user has no role in are treated as missing.
+func authorizeList(conn *Conn, res *httpextra.Response, user *User, id, role string) *List {
+	list, err := conn.GetList(id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil
+	}
+
+	if list == nil || list.Role(user.Name) == "" {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return nil
+	}
+
+	if !list.Can(user.Name, role) {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusForbidden)}, http.StatusForbidden)
+		return nil
+	}
+
+	return list
+}
+
+// getListTask gets a task in a list, responding if it doesn't exist or if the If-Match value
+// doesn't match its revision, and watches it like getTaskIfMatch. The task is modified as the
+// list owner but the change is attributed to the user.
+func getListTask(conn *Conn, res *httpextra.Response, user *User, list *List, id,
+	ifMatch string) *Task {
+	err := conn.WatchTask(list.Owner, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil
+	}
+
+	task, err := conn.GetTask(list.Owner, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil
+	}
+
+	if task == nil || task.List != list.ID {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return nil
+	}
+	task.User = &User{Conn: conn, Name: list.Owner}
+	task.Actor = user
+
+	if ifMatch != "" && !matchRevision(ifMatch, task.Revision) {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusPreconditionFailed)},
+			http.StatusPreconditionFailed)
+		return nil
+	}
+
+	return task
+}
+
+// saveMemberActivities records a membership change made by user for both the list owner and
+// the member, the acting device is only given to the users own activity.
+func saveMemberActivities(conn *Conn, user *User, list *List, member, activityType, ownerMessage,
+	memberMessage string) error {
+	for _, name := range []string{list.Owner, member} {
+		meta := map[string]string{"list": list.ID, "name": list.Name, "member": member, "by": user.Name}
+		if role := list.Members[member]; role != "" {
+			meta["role"] = role
+		}
+
+		activity := &Activity{Conn: conn, Type: activityType, Message: ownerMessage, Meta: meta,
+			User: &User{Name: name}}
+		if name == member {
+			activity.Message = memberMessage
+		}
+		if name == user.Name {
+			activity.Device = user.Device
+		}
+
+		err := activity.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func CreateListHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+
+	list := &List{Conn: conn, Name: params.Get("name"), Owner: user.Name}
+	errs, err := list.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	err = list.Save(true)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(list, http.StatusOK)
+}
+
+func GetListsHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	lists, err := conn.GetLists(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(lists, http.StatusOK)
+}
+
+func GetListHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleViewer)
+	if list == nil {
+		return
+	}
+
+	res.Send(list, http.StatusOK)
+}
+
+func DeleteListHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleOwner)
+	if list == nil {
+		return
+	}
+
+	members := make([]string, 0)
+	for member := range list.Members {
+		members = append(members, member)
+	}
+
+	err := list.Delete()
+	if err == ErrTransactionAborted {
+		status := abortedStatus("")
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	for _, member := range members {
+		err = saveMemberActivities(conn, user, list, member, ActivityListMemberRemoved,
+			"Removed "+member+" from deleted list "+list.Name, "Removed from list "+list.Name+" deleted by "+list.Owner)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	res.Send(list, http.StatusOK)
+}
+
+func SetListMemberHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	member := mux.Vars(req)["member"]
+	role := params.Get("role")
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleOwner)
+	if list == nil {
+		return
+	}
+
+	errs, err := Validations(func() (error, error) {
+		if role != RoleViewer && role != RoleEditor {
+			return ErrListRoleInvalid, nil
+		}
+
+		return nil, nil
+	}, func() (error, error) {
+		if member == list.Owner {
+			return ErrListMemberOwner, nil
+		}
+
+		exists, err := conn.UserExists(member)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return ErrListMemberNotFound, nil
+		}
+
+		return nil, nil
+	})
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	if list.Members[member] == role {
+		res.Send(list, http.StatusOK)
+		return
+	}
+
+	err = list.SetMember(member, role)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = saveMemberActivities(conn, user, list, member, ActivityListMemberSet,
+		"Set "+member+" as "+role+" of list "+list.Name, "Set as "+role+" of list "+list.Name+" by "+list.Owner)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(list, http.StatusOK)
+}
+
+func DeleteListMemberHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	member := mux.Vars(req)["member"]
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	// Members can remove themselves, only the owner can remove others
+	role := RoleOwner
+	if member == user.Name {
+		role = RoleViewer
+	}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], role)
+	if list == nil {
+		return
+	}
+
+	if _, ok := list.Members[member]; !ok {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return
+	}
+
+	err := list.RemoveMember(member)
+	if err == ErrTransactionAborted {
+		status := abortedStatus("")
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = saveMemberActivities(conn, user, list, member, ActivityListMemberRemoved,
+		"Removed "+member+" from list "+list.Name, "Removed from list "+list.Name+" by "+user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(list, http.StatusOK)
+}
+
+func CreateListTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleEditor)
+	if list == nil {
+		return
+	}
+
+	task := &Task{Conn: conn, User: &User{Conn: conn, Name: list.Owner}, Actor: user}
+	setTaskParams(task, params)
+	task.List = list.ID
+	errs, err := task.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	// Ids can't be generated inside the transaction since replies are queued
+	task.ID, err = conn.NextTaskID(list.Owner)
+	if err == nil {
+		err = conn.Transaction(func() error {
+			return task.Save(false)
+		})
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(task, http.StatusOK)
+}
+
+func GetListTasksHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleViewer)
+	if list == nil {
+		return
+	}
+
+	tasks, err := conn.GetListTasks(list)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(tasks, http.StatusOK)
+}
+
+func GetListTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleViewer)
+	if list == nil {
+		return
+	}
+
+	task := getListTask(conn, res, user, list, mux.Vars(req)["id"], "")
+	if task == nil {
+		return
+	}
+	etag := ETag(task.Revision)
+	rw.Header().Set("ETag", etag)
+
+	if MatchETag(req.Header.Get("If-None-Match"), etag, true) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	res.Send(task, http.StatusOK)
+}
+
+func UpdateListTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleEditor)
+	if list == nil {
+		return
+	}
+
+	ifMatch := req.Header.Get("If-Match")
+	task := getListTask(conn, res, user, list, mux.Vars(req)["id"], ifMatch)
+	if task == nil {
+		return
+	}
+
+	// Tasks can only be moved out of a list by the owner through their own tasks
+	if !setTaskParams(task, params) {
+		rw.Header().Set("ETag", ETag(task.Revision))
+		res.Send(task, http.StatusOK)
+		return
+	}
+	task.List = list.ID
+	errs, err := task.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	err = conn.Transaction(func() error {
+		return task.Save(false)
+	})
+	if err == ErrTransactionAborted {
+		status := abortedStatus(ifMatch)
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("ETag", ETag(task.Revision))
+	res.Send(task, http.StatusOK)
+}
+
+func DeleteListTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	list := authorizeList(conn, res, user, mux.Vars(req)["list"], RoleEditor)
+	if list == nil {
+		return
+	}
+
+	ifMatch := req.Header.Get("If-Match")
+	task := getListTask(conn, res, user, list, mux.Vars(req)["id"], ifMatch)
+	if task == nil {
+		return
+	}
+
+	err := conn.Transaction(func() error {
+		return task.Trash()
+	})
+	if err == ErrTransactionAborted {
+		status := abortedStatus(ifMatch)
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(task, http.StatusOK)
+}
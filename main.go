@@ -14,6 +14,8 @@ import (
 
 var (
 	Pool         *DBPool
+	Events       *EventHub
+	APIHandler   http.Handler
 	Config       *config.Config
 	ContentTypes = make(map[string]*httpextra.ContentType)
 	Routes       = make([]*Route, 0)
@@ -46,8 +48,33 @@ func main() {
 	Pool = NewDBPool()
 	defer Pool.Close()
 
+	notifiers, err := NewNotifiers(Config.Notifiers)
+	if err != nil {
+		errorLogger.Fatalln(err)
+	}
+
+	webhooks := NewWebhookSender(NewWebhookClient(), errorLogger)
+	webhooks.Start()
+	defer webhooks.Stop()
+
+	scheduler := NewScheduler(Config.SchedulerTick, errorLogger)
+	scheduler.Add(RemindJob(notifiers), PurgeTrashJob, TrimActivitiesJob(), webhooks.Job())
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	Events = NewEventHub(errorLogger)
+	Events.Start()
+	defer Events.Stop()
+
 	ContentTypes["application/json"] = &httpextra.ContentType{"application/json", ".json",
 		"{\"error\": \"{{message}}\"}", json.Marshal, true}
+	ContentTypes["text/x-todo"] = &httpextra.ContentType{"text/x-todo", ".txt",
+		"error: {{message}}", MarshalTodo, false}
+	ContentTypes["text/calendar"] = &httpextra.ContentType{"text/calendar", ".ics",
+		"error: {{message}}", MarshalICal, false}
+	ContentTypes[CSVType] = &httpextra.ContentType{CSVType, ".csv", "error: {{message}}", MarshalCSV, false}
+	ContentTypes[NDJSONType] = &httpextra.ContentType{NDJSONType, ".ndjson",
+		"{\"error\": \"{{message}}\"}", MarshalNDJSON, false}
 	router := mux.NewRouter()
 	router.NotFoundHandler = httpextra.NewNotFoundHandler(ContentTypes)
 
@@ -57,10 +84,12 @@ func main() {
 		route.HandlerFunc(r.Handler)
 	}
 
+	// Socket commands are handled by the same routes
+	APIHandler = httpextra.NewContentTypeHandler(ContentTypes, router)
+
 	server := &http.Server{
-		Addr: Config.ServerAddr,
-		Handler: httpextra.NewSlashHandler(httpextra.NewLogHandler(logFile,
-			httpextra.NewContentTypeHandler(ContentTypes, router))),
+		Addr:         Config.ServerAddr,
+		Handler:      httpextra.NewSlashHandler(httpextra.NewLogHandler(logFile, APIHandler)),
 		ReadTimeout:  Config.ServerMaxTimeout,
 		WriteTimeout: Config.ServerMaxTimeout,
 	}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier delivers a task reminder to a user. Name is the name it's configured with.
+type Notifier interface {
+	Name() string
+	Notify(conn *Conn, user *User, task *Task) error
+}
+
+// NewNotifiers creates the notifiers with the given names.
+func NewNotifiers(names []string) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0)
+
+	for _, name := range names {
+		switch name {
+		case "activity":
+			notifiers = append(notifiers, new(ActivityNotifier))
+		case "webhook":
+			notifiers = append(notifiers, &WebhookNotifier{Config.WebhookURL,
+				&http.Client{Timeout: Config.ServerMaxTimeout}})
+		case "email":
+			if Config.SMTP == nil {
+				return nil, ErrNotifierNoSMTP
+			}
+
+			notifiers = append(notifiers, &EmailNotifier{Config.SMTP.Addr, Config.SMTP.Username,
+				Config.SMTP.Password, Config.SMTP.From})
+		default:
+			return nil, ErrNotifierUnknown
+		}
+	}
+
+	return notifiers, nil
+}
+
+// ActivityNotifier notifies by adding an activity for the user.
+type ActivityNotifier struct{}
+
+func (notifier *ActivityNotifier) Name() string {
+	return "activity"
+}
+
+// Notify saves a reminder activity.
+func (notifier *ActivityNotifier) Notify(conn *Conn, user *User, task *Task) error {
+	id := strconv.Itoa(task.ID)
+	activity := &Activity{Conn: conn, Type: ActivityTaskReminded, Message: "Reminder for task " + id + ": " +
+		task.Message, Meta: map[string]string{"task": id, "remind": task.Remind}, User: user}
+
+	return activity.Save()
+}
+
+// WebhookNotifier notifies by posting the user and task as JSON to a URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (notifier *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Notify posts the reminder, failing if a non 2xx status is returned.
+func (notifier *WebhookNotifier) Notify(conn *Conn, user *User, task *Task) error {
+	body, err := json.Marshal(map[string]interface{}{"user": user, "task": task})
+	if err != nil {
+		return err
+	}
+
+	res, err := notifier.Client.Post(notifier.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return ErrNotifierWebhookStatus
+	}
+
+	return nil
+}
+
+// EmailNotifier notifies by sending an email to the users address.
+type EmailNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (notifier *EmailNotifier) Name() string {
+	return "email"
+}
+
+// Notify sends the reminder, users without an email address are skipped.
+func (notifier *EmailNotifier) Notify(conn *Conn, user *User, task *Task) error {
+	if user.Email == "" {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if notifier.Username != "" {
+		host := strings.SplitN(notifier.Addr, ":", 2)[0]
+		auth = smtp.PlainAuth("", notifier.Username, notifier.Password, host)
+	}
+
+	// Messages may span lines, which would break the subject header
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(task.Message)
+
+	msg := "From: " + notifier.From + "\r\n" +
+		"To: " + user.Email + "\r\n" +
+		"Subject: Reminder: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n\r\n" +
+		task.Message + "\r\n"
+
+	return smtp.SendMail(notifier.Addr, auth, notifier.From, []string{user.Email}, []byte(msg))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/larzconwell/httpextra"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+// MergePatchType is the media type for JSON Merge Patch (rfc 7396) request bodies.
+const MergePatchType = "application/merge-patch+json"
+
+// PatchField applies a single merge patch member to a model field. Kind describes
+// the values Valid accepts, Set is only called with valid values.
+type PatchField struct {
+	Kind  string
+	Valid func(value interface{}) bool
+	Set   func(value interface{})
+}
+
+// PatchString creates a field accepting strings, null clears the string.
+func PatchString(field *string) *PatchField {
+	return &PatchField{"a string", func(value interface{}) bool {
+		_, ok := value.(string)
+		return ok || value == nil
+	}, func(value interface{}) {
+		*field, _ = value.(string)
+	}}
+}
+
+// PatchBool creates a field accepting booleans, null sets false.
+func PatchBool(field *bool) *PatchField {
+	return &PatchField{"a boolean", func(value interface{}) bool {
+		_, ok := value.(bool)
+		return ok || value == nil
+	}, func(value interface{}) {
+		*field, _ = value.(bool)
+	}}
+}
+
+// PatchStrings creates a field accepting arrays of strings, null clears the slice.
+func PatchStrings(field *[]string) *PatchField {
+	return &PatchField{"an array of strings", func(value interface{}) bool {
+		if value == nil {
+			return true
+		}
+
+		items, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+
+		for _, item := range items {
+			if _, ok := item.(string); !ok {
+				return false
+			}
+		}
+
+		return true
+	}, func(value interface{}) {
+		items, _ := value.([]interface{})
+
+		*field = make([]string, 0)
+		for _, item := range items {
+			*field = append(*field, item.(string))
+		}
+	}}
+}
+
+// ApplyPatch applies a merge patch to the given model fields, returning validation errors
+// for members that aren't patchable or have the wrong type. Nothing is changed if there
+// are any errors.
+func ApplyPatch(model string, patch map[string]interface{}, fields map[string]*PatchField) []string {
+	var errs []string
+	names := make([]string, 0)
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			errs = append(errs, model+": "+name+" cannot be patched")
+			continue
+		}
+
+		if !field.Valid(patch[name]) {
+			errs = append(errs, model+": "+name+" must be "+field.Kind+" or null")
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+
+	for _, name := range names {
+		fields[name].Set(patch[name])
+	}
+
+	return nil
+}
+
+// ParsePatch reads a merge patch request body, responding if it's not a merge patch
+// document or not a JSON object.
+func ParsePatch(rw http.ResponseWriter, req *http.Request) (map[string]interface{}, bool) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != MergePatchType {
+		res := &httpextra.Response{ContentTypes, rw, req}
+
+		rw.Header().Set("Accept-Patch", MergePatchType)
+		res.Send(map[string]string{"error": http.StatusText(http.StatusUnsupportedMediaType)},
+			http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	var patch map[string]interface{}
+	err := json.NewDecoder(req.Body).Decode(&patch)
+	if err != nil || patch == nil {
+		HandleValidations(rw, req, []string{ErrPatchNotObject.Error()}, nil)
+		return nil, false
+	}
+
+	return patch, true
+}
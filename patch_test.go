@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	task := &Task{Message: "Buy milk", Category: "home", Complete: true}
+
+	errs := task.Patch(map[string]interface{}{
+		"message":  "Buy bread",
+		"category": nil,
+		"tags":     []interface{}{"Shop", "food", "shop"},
+	})
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	if task.Message != "Buy bread" {
+		t.Error("message was not patched")
+	}
+	if task.Category != "" {
+		t.Error("null category was not cleared")
+	}
+	if !task.Complete {
+		t.Error("complete was changed without being given")
+	}
+	if len(task.Tags) != 2 || task.Tags[0] != "shop" || task.Tags[1] != "food" {
+		t.Error("tags were not patched and parsed")
+	}
+}
+
+func TestApplyPatchInvalid(t *testing.T) {
+	task := &Task{Message: "Buy milk"}
+
+	errs := task.Patch(map[string]interface{}{
+		"message":  "Buy bread",
+		"complete": "yes",
+		"id":       float64(4),
+		"tags":     []interface{}{"food", 1},
+	})
+	if len(errs) != 3 {
+		t.Fatal("expected 3 validation errors, got", errs)
+	}
+
+	if errs[0] != "Task: complete must be a boolean or null" {
+		t.Error("unexpected error for complete:", errs[0])
+	}
+	if errs[1] != "Task: id cannot be patched" {
+		t.Error("unexpected error for id:", errs[1])
+	}
+	if errs[2] != "Task: tags must be an array of strings or null" {
+		t.Error("unexpected error for tags:", errs[2])
+	}
+	if task.Message != "Buy milk" {
+		t.Error("task was modified by an invalid patch")
+	}
+}
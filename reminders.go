@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// reminderBatch is the most reminders claimed on a single tick.
+const reminderBatch = 100
+
+// reminderLeaseDefault is the lease used if none is configured.
+const reminderLeaseDefault = time.Minute
+
+// RemindJob creates a job that delivers due task reminders through the given notifiers.
+// Reminders that fail to deliver are retried once their lease expires, by the notifiers
+// that failed.
+func RemindJob(notifiers []Notifier) Job {
+	lease := Config.ReminderLease
+	if lease <= 0 {
+		lease = reminderLeaseDefault
+	}
+
+	return func(conn *Conn) error {
+		reminders, err := conn.ClaimReminders(time.Now(), lease, reminderBatch)
+		if err != nil {
+			return err
+		}
+
+		// Keep delivering the rest if one fails, the last error is reported
+		var failed error
+		for _, reminder := range reminders {
+			err = deliverReminder(conn, notifiers, reminder)
+			if err != nil {
+				failed = err
+			}
+		}
+
+		return failed
+	}
+}
+
+// deliverReminder sends a claimed reminder to each notifier that hasn't delivered it yet,
+// and acknowledges it if they all succeed or the task no longer needs reminding.
+func deliverReminder(conn *Conn, notifiers []Notifier, reminder string) error {
+	i := strings.LastIndex(reminder, ":")
+	name, id := reminder[:i], reminder[i+1:]
+
+	user, err := conn.GetUser(name)
+	if err != nil {
+		return err
+	}
+
+	var task *Task
+	if user != nil {
+		task, err = conn.GetTask(name, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The task may have been completed or rescheduled since the reminder was queued, a
+	// rescheduled reminder is queued again when the task is saved
+	if task != nil && !reminderDue(task, time.Now()) {
+		task = nil
+	}
+
+	if task != nil {
+		task.User = user
+
+		delivered, err := conn.GetReminderDeliveries(reminder)
+		if err != nil {
+			return err
+		}
+
+		// Retried reminders skip the notifiers that delivered them, unless the task was
+		// rescheduled since
+		var failed error
+		for _, notifier := range notifiers {
+			remind, ok := delivered[notifier.Name()]
+			if ok && remind == task.Remind {
+				continue
+			}
+
+			err = notifier.Notify(conn, user, task)
+			if err != nil {
+				failed = err
+				continue
+			}
+
+			err = conn.RecordReminderDelivery(reminder, notifier.Name(), task.Remind)
+			if err != nil {
+				return err
+			}
+		}
+
+		if failed != nil {
+			return failed
+		}
+	}
+
+	return conn.AckReminder(reminder)
+}
+
+// reminderDue checks if a tasks reminder should be delivered by the given time.
+func reminderDue(task *Task, now time.Time) bool {
+	if task.Complete {
+		return false
+	}
+
+	remind, err := time.Parse(time.RFC3339, task.Remind)
+	return err == nil && !remind.After(now)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReminderDue(t *testing.T) {
+	now := time.Now()
+	task := &Task{Remind: now.Add(-time.Minute).Format(time.RFC3339)}
+
+	if !reminderDue(task, now) {
+		t.Error("past reminder was not due")
+	}
+
+	task.Complete = true
+	if reminderDue(task, now) {
+		t.Error("reminder for a complete task was due")
+	}
+
+	task.Complete = false
+	task.Remind = now.Add(time.Hour).Format(time.RFC3339)
+	if reminderDue(task, now) {
+		t.Error("rescheduled reminder was due")
+	}
+
+	task.Remind = ""
+	if reminderDue(task, now) {
+		t.Error("cleared reminder was due")
+	}
+}
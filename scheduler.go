@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// schedulerTickDefault is the tick used if none is configured.
+const schedulerTickDefault = 10 * time.Second
+
+// Job is a unit of background work run by the scheduler on every tick.
+type Job func(conn *Conn) error
+
+// Scheduler runs jobs in the background at a fixed interval.
+type Scheduler struct {
+	Tick   time.Duration
+	Jobs   []Job
+	Logger *log.Logger
+	stop   chan bool
+}
+
+// NewScheduler creates a scheduler that logs job errors to the given logger.
+func NewScheduler(tick time.Duration, logger *log.Logger) *Scheduler {
+	if tick <= 0 {
+		tick = schedulerTickDefault
+	}
+
+	return &Scheduler{Tick: tick, Jobs: make([]Job, 0), Logger: logger, stop: make(chan bool)}
+}
+
+// Add adds jobs to run on every tick.
+func (scheduler *Scheduler) Add(jobs ...Job) {
+	scheduler.Jobs = append(scheduler.Jobs, jobs...)
+}
+
+// Start runs the jobs immediately and then every tick until stopped.
+func (scheduler *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(scheduler.Tick)
+		defer ticker.Stop()
+
+		for {
+			scheduler.run()
+
+			select {
+			case <-ticker.C:
+			case <-scheduler.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops running the jobs.
+func (scheduler *Scheduler) Stop() {
+	close(scheduler.stop)
+}
+
+// run runs each job once, a failing job doesn't prevent the others from running.
+func (scheduler *Scheduler) run() {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	for _, job := range scheduler.Jobs {
+		err := job(conn)
+		if err != nil {
+			scheduler.Logger.Println(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/larzconwell/httpextra"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// SearchTypes maps search document types to a function retrieving the item for a users
+// document id. Resources register here to be included in search results.
+var SearchTypes = make(map[string]func(conn *Conn, user, id string) (interface{}, error))
+
+func init() {
+	search := &Route{"Search", "/search", []string{"GET"}, SearchHandler}
+
+	Routes = append(Routes, search)
+
+	SearchTypes["task"] = func(conn *Conn, user, id string) (interface{}, error) {
+		task, err := conn.GetTask(user, id)
+		if task == nil {
+			// Avoid returning a non-nil interface holding a nil task
+			return nil, err
+		}
+
+		return task, err
+	}
+}
+
+// Searchable is an item that can be indexed for a user.
+type Searchable interface {
+	// SearchDoc gets the document name, in the format <type>:<id>.
+	SearchDoc() string
+	// SearchText gets the text to index.
+	SearchText() []string
+}
+
+// SearchResult represents a single item matching a search.
+type SearchResult struct {
+	Type  string      `json:"type"`
+	ID    string      `json:"-"`
+	Score float64     `json:"score"`
+	Item  interface{} `json:"item"`
+}
+
+// searchResults sorts results by score, highest first.
+type searchResults []*SearchResult
+
+func (results searchResults) Len() int {
+	return len(results)
+}
+
+func (results searchResults) Less(i, j int) bool {
+	if results[i].Score == results[j].Score {
+		return results[i].Type+results[i].ID < results[j].Type+results[j].ID
+	}
+
+	return results[i].Score > results[j].Score
+}
+
+func (results searchResults) Swap(i, j int) {
+	results[i], results[j] = results[j], results[i]
+}
+
+// Terms splits text into lowercase words, counting how often each occurs.
+func Terms(text ...string) map[string]int {
+	terms := make(map[string]int)
+
+	for _, item := range text {
+		words := strings.FieldsFunc(strings.ToLower(item), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+
+		for _, word := range words {
+			terms[word]++
+		}
+	}
+
+	return terms
+}
+
+func SearchHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+
+	terms := make([]string, 0)
+	for term := range Terms(req.URL.Query().Get("q")) {
+		terms = append(terms, term)
+	}
+	if len(terms) <= 0 {
+		HandleValidations(rw, req, []string{ErrSearchQueryEmpty.Error()}, nil)
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	results, err := conn.Search(user.Name, terms)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	// Resolve the items, skipping types that aren't registered or were since removed
+	found := make([]*SearchResult, 0)
+	for _, result := range results {
+		get, ok := SearchTypes[result.Type]
+		if !ok {
+			continue
+		}
+
+		result.Item, err = get(conn, user.Name, result.ID)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+
+		if result.Item != nil {
+			found = append(found, result)
+		}
+	}
+
+	res.Send(found, http.StatusOK)
+}
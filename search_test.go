@@ -0,0 +1,19 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	terms := Terms("Buy milk, buy BREAD!", "café-2 milk", "")
+	expected := map[string]int{"buy": 2, "milk": 2, "bread": 1, "café": 1, "2": 1}
+
+	if !reflect.DeepEqual(terms, expected) {
+		t.Error("expected", expected, "got", terms)
+	}
+
+	if len(Terms("--- ...")) != 0 {
+		t.Error("expected punctuation to have no terms")
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// socketReadMax is the most bytes in a command, enough for a task with the largest notes.
+const socketReadMax = 128 * 1024
+
+// socketWriteWait is how long a message can take to be written before the socket is closed.
+const socketWriteWait = 10 * time.Second
+
+var upgrader = &websocket.Upgrader{}
+
+func init() {
+	socket := &Route{"Socket", "/socket", []string{"GET"}, SocketHandler}
+
+	Routes = append(Routes, socket)
+}
+
+// SocketCommand represents a task command sent on a socket, it's run as the REST request for
+// the op. Revision is given as If-Match, for create data is the task fields, for update it's a
+// merge patch, and for delete it's {"hard": true} for a hard delete.
+type SocketCommand struct {
+	ID       string          `json:"id"`
+	Op       string          `json:"op"`
+	Task     int             `json:"task"`
+	Revision *int            `json:"revision"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// SocketEvent represents an event sent on a socket.
+type SocketEvent struct {
+	Type  string          `json:"type"`
+	ID    int64           `json:"id,omitempty"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// SocketReply represents the response to a command, data is the response body.
+type SocketReply struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Request creates the REST request for the command, authenticated the same as the socket.
+func (command *SocketCommand) Request(socket *http.Request) (*http.Request, error) {
+	path := "/tasks/" + strconv.Itoa(command.Task)
+	method := ""
+	body := ""
+	contentType := ""
+
+	switch command.Op {
+	case "create":
+		values, err := socketForm(command.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		method = "POST"
+		path = "/tasks"
+		body = values.Encode()
+		contentType = "application/x-www-form-urlencoded"
+	case "update":
+		method = "PATCH"
+		body = string(command.Data)
+		contentType = "application/merge-patch+json"
+	case "delete":
+		values, err := socketForm(command.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		method = "DELETE"
+		if values.Get("hard") != "" {
+			path += "?hard=" + url.QueryEscape(values.Get("hard"))
+		}
+	default:
+		return nil, ErrSocketOpInvalid
+	}
+
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Host = socket.Host
+	req.RemoteAddr = socket.RemoteAddr
+	req.Header.Set("Authorization", socket.Header.Get("Authorization"))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if command.Revision != nil {
+		req.Header.Set("If-Match", ETag(*command.Revision))
+	}
+
+	return req, nil
+}
+
+// socketForm converts command data to form values, arrays are joined with commas.
+func socketForm(data json.RawMessage) (url.Values, error) {
+	values := make(url.Values)
+	if len(data) <= 0 {
+		return values, nil
+	}
+
+	var fields map[string]interface{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, ErrSocketDataInvalid
+	}
+
+	for name, value := range fields {
+		switch value := value.(type) {
+		case nil:
+			values.Set(name, "")
+		case string:
+			values.Set(name, value)
+		case bool:
+			values.Set(name, strconv.FormatBool(value))
+		case float64:
+			values.Set(name, strconv.FormatFloat(value, 'f', -1, 64))
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				str, ok := item.(string)
+				if !ok {
+					return nil, ErrSocketDataInvalid
+				}
+				items = append(items, str)
+			}
+
+			values.Set(name, strings.Join(items, ","))
+		default:
+			return nil, ErrSocketDataInvalid
+		}
+	}
+
+	return values, nil
+}
+
+// socketResponse records the response to a command.
+type socketResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (res *socketResponse) Header() http.Header {
+	return res.header
+}
+
+func (res *socketResponse) Write(data []byte) (int, error) {
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+
+	return res.body.Write(data)
+}
+
+func (res *socketResponse) WriteHeader(status int) {
+	if res.status == 0 {
+		res.status = status
+	}
+}
+
+// runCommand runs a command through the API routes, replying with the response.
+func runCommand(socket *http.Request, message []byte) *SocketReply {
+	reply := &SocketReply{Type: "reply"}
+	errorReply := func(err error, status int) *SocketReply {
+		reply.Status = status
+		reply.Data, _ = json.Marshal(map[string]string{"error": err.Error()})
+		return reply
+	}
+
+	command := new(SocketCommand)
+	err := json.Unmarshal(message, command)
+	if err != nil {
+		return errorReply(ErrSocketCommandInvalid, http.StatusBadRequest)
+	}
+	reply.ID = command.ID
+
+	req, err := command.Request(socket)
+	if err != nil {
+		return errorReply(err, http.StatusBadRequest)
+	}
+
+	res := &socketResponse{header: make(http.Header)}
+	APIHandler.ServeHTTP(res, req)
+	reply.Status = res.status
+
+	reply.Data = res.body.Bytes()
+	if !json.Valid(reply.Data) {
+		reply.Data, _ = json.Marshal(res.body.String())
+	}
+
+	return reply
+}
+
+// readCommands runs the commands sent on the socket until it's closed or quit is closed.
+func readCommands(ws *websocket.Conn, socket *http.Request, replies chan *SocketReply, quit chan bool) {
+	defer close(replies)
+
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if Config.EventHeartbeat > 0 {
+			ws.SetReadDeadline(time.Now().Add(2 * Config.EventHeartbeat))
+		}
+
+		select {
+		case replies <- runCommand(socket, message):
+		case <-quit:
+			return
+		}
+	}
+}
+
+func SocketHandler(rw http.ResponseWriter, req *http.Request) {
+	queryToken(req)
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+
+	stream, missed, reset, ok := openEventStream(conn, rw, req, user)
+	if !ok {
+		return
+	}
+	defer Events.Unsubscribe(user.Name, stream)
+
+	// The connection isn't needed while the socket is open, commands get their own
+	conn.Close()
+
+	// Upgrade responds to the request if it fails
+	ws, err := upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(socketReadMax)
+
+	// Sockets that don't respond to pings are closed
+	var heartbeat <-chan time.Time
+	if Config.EventHeartbeat > 0 {
+		ticker := time.NewTicker(Config.EventHeartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+
+		ws.SetReadDeadline(time.Now().Add(2 * Config.EventHeartbeat))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(2 * Config.EventHeartbeat))
+		})
+	}
+
+	replies := make(chan *SocketReply)
+	quit := make(chan bool)
+	defer close(quit)
+	go readCommands(ws, req, replies, quit)
+
+	write := func(message interface{}) bool {
+		ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		return ws.WriteJSON(message) == nil
+	}
+
+	if reset {
+		if !write(&SocketEvent{Type: "event", Event: "reset", Data: json.RawMessage("{}")}) {
+			return
+		}
+	}
+	var lastID int64
+	for _, event := range missed {
+		if !write(&SocketEvent{"event", event.ID, event.Type, json.RawMessage(event.Data)}) {
+			return
+		}
+		lastID = event.ID
+	}
+
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater,
+					""), time.Now().Add(socketWriteWait))
+				return
+			}
+
+			// Events may have been given as missed events already
+			if event.ID <= lastID {
+				continue
+			}
+			lastID = event.ID
+
+			if !write(&SocketEvent{"event", event.ID, event.Type, json.RawMessage(event.Data)}) {
+				return
+			}
+		case reply, ok := <-replies:
+			if !ok || !write(reply) {
+				return
+			}
+		case <-heartbeat:
+			err = ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
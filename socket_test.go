@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestSocketCommandRequest(t *testing.T) {
+	socket, _ := http.NewRequest("GET", "/socket", nil)
+	socket.Header.Set("Authorization", "Token abc")
+	revision := 3
+
+	command := &SocketCommand{Op: "create", Data: json.RawMessage(`{"message": "Buy milk",
+		"tags": ["shop", "food"], "complete": true}`)}
+	req, err := command.Request(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ParseForm()
+
+	if req.Method != "POST" || req.URL.Path != "/tasks" {
+		t.Error("expected POST /tasks, got", req.Method, req.URL.Path)
+	}
+	if req.Form.Get("tags") != "shop,food" || req.Form.Get("complete") != "true" {
+		t.Error("data was converted incorrectly", req.Form)
+	}
+	if req.Header.Get("Authorization") != "Token abc" {
+		t.Error("authorization wasn't given")
+	}
+
+	command = &SocketCommand{Op: "update", Task: 4, Revision: &revision, Data: json.RawMessage(`{"notes": null}`)}
+	req, err = command.Request(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+
+	if req.Method != "PATCH" || req.URL.Path != "/tasks/4" || string(body) != `{"notes": null}` {
+		t.Error("expected a PATCH /tasks/4 merge patch, got", req.Method, req.URL.Path, string(body))
+	}
+	if req.Header.Get("If-Match") != "\"3\"" {
+		t.Error("revision wasn't given as If-Match")
+	}
+
+	command = &SocketCommand{Op: "delete", Task: 4, Data: json.RawMessage(`{"hard": true}`)}
+	req, err = command.Request(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Method != "DELETE" || req.URL.RequestURI() != "/tasks/4?hard=true" {
+		t.Error("expected DELETE /tasks/4?hard=true, got", req.Method, req.URL.RequestURI())
+	}
+
+	_, err = (&SocketCommand{Op: "move"}).Request(socket)
+	if err != ErrSocketOpInvalid {
+		t.Error("expected an invalid op error, got", err)
+	}
+
+	_, err = (&SocketCommand{Op: "create", Data: json.RawMessage(`{"tags": [1]}`)}).Request(socket)
+	if err != ErrSocketDataInvalid {
+		t.Error("expected an invalid data error, got", err)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	APIHandler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte(`{"path": "` + req.URL.Path + `"}`))
+	})
+	defer func() { APIHandler = nil }()
+	socket, _ := http.NewRequest("GET", "/socket", nil)
+
+	reply := runCommand(socket, []byte(`{"id": "a", "op": "delete", "task": 2}`))
+	if reply.ID != "a" || reply.Status != http.StatusCreated || string(reply.Data) != `{"path": "/tasks/2"}` {
+		t.Error("reply is incorrect", reply.ID, reply.Status, string(reply.Data))
+	}
+
+	reply = runCommand(socket, []byte(`not json`))
+	if reply.Status != http.StatusBadRequest {
+		t.Error("expected a bad request reply, got", reply.Status)
+	}
+}
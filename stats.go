@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/larzconwell/httpextra"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits for the number of days stats are given for.
+const (
+	statsDaysDefault = 30
+	statsDaysMax     = 365
+)
+
+// CategoryStats represents the number of open and complete tasks in a category.
+type CategoryStats struct {
+	Open     int `json:"open"`
+	Complete int `json:"complete"`
+}
+
+// DayStats represents the number of tasks completed on a day.
+type DayStats struct {
+	Date      string `json:"date"`
+	Completed int    `json:"completed"`
+}
+
+// TaskStats represents statistics for a users tasks. AverageComplete is the average
+// number of seconds from a task being created to being completed.
+type TaskStats struct {
+	Categories      map[string]*CategoryStats `json:"categories"`
+	Days            []*DayStats               `json:"days"`
+	AverageComplete float64                   `json:"averageComplete"`
+}
+
+// NewTaskStats computes statistics for tasks, with completions per day for the
+// given number of days up to now in UTC.
+func NewTaskStats(tasks []*Task, now time.Time, days int) *TaskStats {
+	stats := &TaskStats{Categories: make(map[string]*CategoryStats), Days: make([]*DayStats, days)}
+	today := now.UTC().Truncate(24 * time.Hour)
+	start := today.AddDate(0, 0, 1-days)
+
+	for i := range stats.Days {
+		stats.Days[i] = &DayStats{Date: start.AddDate(0, 0, i).Format(todoDate)}
+	}
+
+	total := 0.0
+	count := 0
+	for _, task := range tasks {
+		category := stats.Categories[task.Category]
+		if category == nil {
+			category = &CategoryStats{}
+			stats.Categories[task.Category] = category
+		}
+
+		if !task.Complete {
+			category.Open++
+			continue
+		}
+		category.Complete++
+
+		// Tasks completed before completion times were recorded aren't counted
+		completed, err := time.Parse(time.RFC3339, task.Completed)
+		if err != nil {
+			continue
+		}
+
+		day := int(completed.UTC().Sub(start).Hours() / 24)
+		if !completed.Before(start) && day < days {
+			stats.Days[day].Completed++
+		}
+
+		created, err := time.Parse(time.RFC3339, task.Created)
+		if err == nil && !completed.Before(created) {
+			total += completed.Sub(created).Seconds()
+			count++
+		}
+	}
+
+	if count > 0 {
+		stats.AverageComplete = total / float64(count)
+	}
+
+	return stats
+}
+
+func GetTaskStatsHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	days := statsDaysDefault
+	if value := req.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > statsDaysMax {
+			HandleValidations(rw, req, []string{ErrStatsDaysInvalid.Error()}, nil)
+			return
+		}
+	}
+
+	tasks, err := conn.GetTasks(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(NewTaskStats(tasks, time.Now(), days), http.StatusOK)
+}
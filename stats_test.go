@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTaskStats(t *testing.T) {
+	tasks := []*Task{
+		{Category: "home"},
+		{Category: "home", Complete: true, Created: "2026-10-18T10:00:00Z", Completed: "2026-10-19T10:00:00Z"},
+		{Category: "work", Complete: true, Created: "2026-10-17T09:00:00Z", Completed: "2026-10-17T12:00:00Z"},
+		{Category: "work", Complete: true, Created: "2026-09-01T00:00:00Z", Completed: "2026-09-02T00:00:00Z"},
+		{Category: "work", Complete: true},
+	}
+	now := time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC)
+
+	stats := NewTaskStats(tasks, now, 3)
+
+	home := stats.Categories["home"]
+	work := stats.Categories["work"]
+	if home == nil || home.Open != 1 || home.Complete != 1 {
+		t.Error("home category counts are wrong")
+	}
+	if work == nil || work.Open != 0 || work.Complete != 3 {
+		t.Error("work category counts are wrong")
+	}
+
+	if len(stats.Days) != 3 {
+		t.Fatal("expected 3 days, got", len(stats.Days))
+	}
+	expected := []DayStats{{"2026-10-17", 1}, {"2026-10-18", 0}, {"2026-10-19", 1}}
+	for i, day := range stats.Days {
+		if *day != expected[i] {
+			t.Error("expected", expected[i], "got", *day)
+		}
+	}
+
+	// One day, three hours, and one day
+	if stats.AverageComplete != (86400+10800+86400)/3.0 {
+		t.Error("average completion time is wrong, got", stats.AverageComplete)
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/larzconwell/httpextra"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits for the number of changes given at once.
+const (
+	syncLimitDefault = 500
+	syncLimitMax     = 1000
+)
+
+// syncUploadMax is the most changes that can be uploaded at once.
+const syncUploadMax = 100
+
+// syncRetries is how many times a change is tried if the task changes while it's applied.
+const syncRetries = 3
+
+// syncBodyMax is the most bytes in an upload, enough for every change to have the largest notes.
+const syncBodyMax = syncUploadMax * 128 * 1024
+
+func init() {
+	getSync := &Route{"GetSync", "/sync", []string{"GET"}, GetSyncHandler}
+	uploadSync := &Route{"UploadSync", "/sync", []string{"POST"}, UploadSyncHandler}
+
+	Routes = append(Routes, getSync, uploadSync)
+}
+
+// SyncDeleted represents the tombstones for items deleted since a cursor, tasks in the trash
+// are deleted.
+type SyncDeleted struct {
+	Tasks   []int    `json:"tasks"`
+	Devices []string `json:"devices"`
+}
+
+// SyncResult represents the items changed since a cursor, and the cursor to sync from next.
+// More is true if there are more changes after the cursor.
+type SyncResult struct {
+	Tasks   []*Task      `json:"tasks"`
+	Devices []*Device    `json:"devices"`
+	Deleted *SyncDeleted `json:"deleted"`
+	Cursor  string       `json:"cursor"`
+	More    bool         `json:"more"`
+}
+
+// NewSyncResult creates an empty result.
+func NewSyncResult() *SyncResult {
+	return &SyncResult{Tasks: make([]*Task, 0), Devices: make([]*Device, 0),
+		Deleted: &SyncDeleted{Tasks: make([]int, 0), Devices: make([]string, 0)}}
+}
+
+// ParseSyncCursor parses an encoded cursor as the change id it's at.
+func ParseSyncCursor(cursor string) (int64, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrSyncCursorInvalid
+	}
+
+	id, err := strconv.ParseInt(string(decoded), 10, 64)
+	if err != nil || id < 0 {
+		return 0, ErrSyncCursorInvalid
+	}
+
+	return id, nil
+}
+
+// FormatSyncCursor encodes the change id a cursor is at.
+func FormatSyncCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+// SyncChange represents a change a client made to a task while offline. Revision is the tasks
+// revision the change was made from. For create the fields are the new tasks fields, and for
+// update they're a merge patch. Ref is given back in the result so clients can match them.
+type SyncChange struct {
+	Ref      string                 `json:"ref"`
+	Op       string                 `json:"op"`
+	ID       int                    `json:"id"`
+	Revision int                    `json:"revision"`
+	Fields   map[string]interface{} `json:"fields"`
+}
+
+// SyncConflict represents a field changed by both the client and another device since the
+// clients revision. Base is the value at the clients revision, null if it's no longer known.
+type SyncConflict struct {
+	Field  string      `json:"field"`
+	Base   interface{} `json:"base"`
+	Server interface{} `json:"server"`
+	Client interface{} `json:"client"`
+}
+
+// SyncChangeResult represents the outcome of an uploaded change. When there are conflicts the
+// fields that don't conflict are still applied, and the task is the merged result.
+type SyncChangeResult struct {
+	Ref       string          `json:"ref,omitempty"`
+	Status    int             `json:"status"`
+	Task      *Task           `json:"task,omitempty"`
+	Conflicts []*SyncConflict `json:"conflicts,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Errors    []string        `json:"errors,omitempty"`
+}
+
+// ChangedSince gets the fields changed after a revision from a tasks history, with the value
+// each had at the revision. Complete is false if the history doesn't go back to the revision,
+// since it's trimmed to max changes, so any field may have changed.
+func ChangedSince(history []*TaskChange, revision, max int) (map[string]interface{}, bool) {
+	changed := make(map[string]interface{})
+
+	// History is most recent first, so older values replace newer ones
+	for _, change := range history {
+		// Changes recorded before revisions were kept can't be placed
+		if change.Revision <= 0 {
+			return changed, false
+		}
+		if change.Revision <= revision {
+			return changed, true
+		}
+
+		for name, field := range change.Fields {
+			changed[name] = field.From
+		}
+	}
+
+	return changed, max <= 0 || len(history) < max
+}
+
+// taskFields gets the values of the patchable task fields.
+func taskFields(task *Task) map[string]interface{} {
+	return map[string]interface{}{
+		"message":  task.Message,
+		"notes":    task.Notes,
+		"category": task.Category,
+		"complete": task.Complete,
+		"priority": task.Priority,
+		"remind":   task.Remind,
+		"tags":     append(make([]string, 0), task.Tags...),
+		"list":     task.List,
+		"assignee": task.Assignee,
+	}
+}
+
+// MergeSyncChange splits the fields a client changed into those that can be applied and those
+// that conflict. A field conflicts if it was changed since the clients revision, or may have
+// been if the history isn't complete, and the client gave it a different value.
+func MergeSyncChange(task *Task, fields, changed map[string]interface{},
+	complete bool) (map[string]interface{}, []*SyncConflict) {
+	merged := make(map[string]interface{})
+	conflicts := make([]*SyncConflict, 0)
+	server := taskFields(task)
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		patched := *task
+		errs := patched.Patch(map[string]interface{}{name: fields[name]})
+		if errs != nil {
+			// Invalid fields are kept so they're reported when the merge is applied
+			merged[name] = fields[name]
+			continue
+		}
+
+		client := taskFields(&patched)[name]
+		if reflect.DeepEqual(client, server[name]) {
+			continue
+		}
+
+		base, ok := changed[name]
+		if !ok && complete {
+			merged[name] = fields[name]
+			continue
+		}
+
+		conflicts = append(conflicts, &SyncConflict{Field: name, Base: base, Server: server[name],
+			Client: client})
+	}
+
+	return merged, conflicts
+}
+
+// deleteConflicts gets the fields changed since the clients revision, a delete conflicts with
+// them since the client didn't see them. If the history isn't complete every field conflicts.
+func deleteConflicts(task *Task, changed map[string]interface{}, complete bool) []*SyncConflict {
+	conflicts := make([]*SyncConflict, 0)
+	server := taskFields(task)
+
+	names := make([]string, 0, len(server))
+	for name := range server {
+		if _, ok := changed[name]; ok || !complete {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		conflicts = append(conflicts, &SyncConflict{Field: name, Base: changed[name], Server: server[name]})
+	}
+
+	return conflicts
+}
+
+// createSyncTask creates the task for an uploaded create. The ref is recorded with the task, so
+// an upload that's given again gets the task it created instead of creating another. The refs
+// are watched so the same ref being created at once is caught.
+func createSyncTask(conn *Conn, user *User, change *SyncChange) (*SyncChangeResult, error) {
+	task := &Task{Conn: conn, User: user, Tags: make([]string, 0), SyncRef: change.Ref}
+
+	errs := task.Patch(change.Fields)
+	if errs != nil {
+		return &SyncChangeResult{Status: http.StatusBadRequest, Errors: errs}, nil
+	}
+
+	errs, err := task.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if errs != nil {
+		return &SyncChangeResult{Status: http.StatusBadRequest, Errors: errs}, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		if change.Ref != "" {
+			result, err := syncRefResult(conn, user, change.Ref)
+			if err != nil || result != nil {
+				conn.Do("unwatch")
+				return result, err
+			}
+		}
+
+		// Ids can't be generated inside the transaction since replies are queued
+		task.ID, err = conn.NextTaskID(user.Name)
+		if err != nil {
+			conn.Do("unwatch")
+			return nil, err
+		}
+
+		err = conn.Transaction(func() error {
+			return task.Save(false)
+		})
+		if err == ErrTransactionAborted {
+			if attempt < syncRetries {
+				continue
+			}
+
+			return &SyncChangeResult{Status: http.StatusConflict,
+				Error: http.StatusText(http.StatusConflict)}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &SyncChangeResult{Status: http.StatusOK, Task: task}, nil
+	}
+}
+
+// syncRefResult watches the sync refs and gets the result for a create that was already
+// applied, which is nil if it wasn't.
+func syncRefResult(conn *Conn, user *User, ref string) (*SyncChangeResult, error) {
+	err := conn.WatchSyncRefs(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := conn.GetSyncRefTask(user.Name, ref)
+	if err != nil || id == "" {
+		return nil, err
+	}
+
+	task, err := conn.GetTask(user.Name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The task may have since been deleted
+	if task == nil {
+		return &SyncChangeResult{Status: http.StatusNotFound,
+			Error: http.StatusText(http.StatusNotFound)}, nil
+	}
+
+	return &SyncChangeResult{Status: http.StatusOK, Task: task}, nil
+}
+
+// applySyncChange merges an uploaded update or delete into the task. The task is watched
+// while it's merged, so if it changes before the merge is saved it's merged again.
+func applySyncChange(conn *Conn, user *User, change *SyncChange) (*SyncChangeResult, error) {
+	id := strconv.Itoa(change.ID)
+
+	for attempt := 0; ; attempt++ {
+		err := conn.WatchTask(user.Name, id)
+		if err != nil {
+			return nil, err
+		}
+
+		result, task, err := mergeSyncTask(conn, user, change)
+		if err != nil || task == nil {
+			conn.Do("unwatch")
+			return result, err
+		}
+
+		err = conn.Transaction(func() error {
+			if change.Op == "delete" {
+				return task.Trash()
+			}
+
+			return task.Save(false)
+		})
+		if err == ErrTransactionAborted {
+			if attempt < syncRetries {
+				continue
+			}
+
+			return &SyncChangeResult{Status: http.StatusConflict,
+				Error: http.StatusText(http.StatusConflict)}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if change.Op == "delete" {
+			result.Task = task
+		}
+		return result, nil
+	}
+}
+
+// mergeSyncTask gets the task for an update or delete and merges the change into it. The task
+// is only given if it should be saved.
+func mergeSyncTask(conn *Conn, user *User, change *SyncChange) (*SyncChangeResult, *Task, error) {
+	task, err := conn.GetTask(user.Name, strconv.Itoa(change.ID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if task == nil {
+		return &SyncChangeResult{Status: http.StatusNotFound,
+			Error: http.StatusText(http.StatusNotFound)}, nil, nil
+	}
+	task.User = user
+
+	if change.Revision < 1 || change.Revision > task.Revision {
+		return &SyncChangeResult{Status: http.StatusBadRequest,
+			Errors: []string{ErrSyncRevisionInvalid.Error()}}, nil, nil
+	}
+
+	changed := make(map[string]interface{})
+	complete := true
+	if change.Revision != task.Revision {
+		history, err := conn.GetTaskHistory(user.Name, strconv.Itoa(task.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changed, complete = ChangedSince(history, change.Revision, Config.HistoryMax)
+	}
+
+	if change.Op == "delete" {
+		conflicts := deleteConflicts(task, changed, complete)
+		if len(conflicts) > 0 {
+			return &SyncChangeResult{Status: http.StatusConflict, Task: task, Conflicts: conflicts}, nil, nil
+		}
+
+		return &SyncChangeResult{Status: http.StatusOK}, task, nil
+	}
+
+	merged, conflicts := MergeSyncChange(task, change.Fields, changed, complete)
+	result := &SyncChangeResult{Status: http.StatusOK, Task: task}
+	if len(conflicts) > 0 {
+		result.Status = http.StatusConflict
+		result.Conflicts = conflicts
+	}
+
+	// Nothing is saved if every field was already the same or conflicts
+	if len(merged) <= 0 {
+		return result, nil, nil
+	}
+
+	errs := task.Patch(merged)
+	if errs == nil {
+		errs, err = task.Validate()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if errs != nil {
+		return &SyncChangeResult{Status: http.StatusBadRequest, Errors: errs}, nil, nil
+	}
+
+	return result, task, nil
+}
+
+// fullSync gets every task and device, with a cursor at the latest change.
+func fullSync(conn *Conn, user *User) (*SyncResult, error) {
+	result := NewSyncResult()
+
+	// The cursor is got first so changes made while syncing are given next time
+	id, err := conn.GetChangeID(user.Name)
+	if err != nil {
+		return nil, err
+	}
+	result.Cursor = FormatSyncCursor(id)
+
+	result.Tasks, err = conn.GetTasks(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Devices, err = conn.GetDevices(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// deltaSync gets up to limit items changed after a change id, deleted items are given as
+// tombstones.
+func deltaSync(conn *Conn, user *User, after int64, limit int) (*SyncResult, error) {
+	result := NewSyncResult()
+	result.Cursor = FormatSyncCursor(after)
+
+	// Get an extra change to know if there are more
+	items, ids, err := conn.GetChanges(user.Name, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) > limit {
+		items = items[:limit]
+		ids = ids[:limit]
+		result.More = true
+	}
+
+	for i, item := range items {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		result.Cursor = FormatSyncCursor(ids[i])
+
+		switch parts[0] {
+		case "task":
+			id, err := strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+
+			task, err := conn.GetTask(user.Name, parts[1])
+			if err != nil {
+				return nil, err
+			}
+
+			if task == nil {
+				result.Deleted.Tasks = append(result.Deleted.Tasks, id)
+			} else {
+				result.Tasks = append(result.Tasks, task)
+			}
+		case "device":
+			device, err := conn.GetDevice(user.Name, parts[1])
+			if err != nil {
+				return nil, err
+			}
+
+			if device == nil {
+				result.Deleted.Devices = append(result.Deleted.Devices, parts[1])
+			} else {
+				result.Devices = append(result.Devices, device)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func GetSyncHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+	query := req.URL.Query()
+
+	var (
+		after int64
+		errs  []string
+		err   error
+	)
+	limit := syncLimitDefault
+
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > syncLimitMax {
+			errs = append(errs, ErrSyncLimitInvalid.Error())
+		}
+	}
+
+	cursor := query.Get("cursor")
+	if cursor != "" {
+		after, err = ParseSyncCursor(cursor)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// Cursors past the latest change aren't from this account's change log
+	if cursor != "" && err == nil {
+		latest, err := conn.GetChangeID(user.Name)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+
+		if after > latest {
+			errs = append(errs, ErrSyncCursorInvalid.Error())
+		}
+	}
+
+	ok := HandleValidations(rw, req, errs, nil)
+	if !ok {
+		return
+	}
+
+	var result *SyncResult
+	if cursor == "" {
+		result, err = fullSync(conn, user)
+	} else {
+		result, err = deltaSync(conn, user, after, limit)
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(result, http.StatusOK)
+}
+
+func UploadSyncHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	var body struct {
+		Changes []*SyncChange `json:"changes"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, syncBodyMax)).Decode(&body)
+	if bodyTooLarge(err) {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusRequestEntityTooLarge)},
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Changes) <= 0 {
+		HandleValidations(rw, req, []string{ErrSyncEmpty.Error()}, nil)
+		return
+	}
+	if len(body.Changes) > syncUploadMax {
+		HandleValidations(rw, req, []string{ErrSyncTooLarge.Error()}, nil)
+		return
+	}
+
+	// Changes are applied in order, each on its own so one failing doesn't affect the rest
+	results := make([]*SyncChangeResult, len(body.Changes))
+	for i, change := range body.Changes {
+		switch change.Op {
+		case "create":
+			results[i], err = createSyncTask(conn, user, change)
+		case "update", "delete":
+			results[i], err = applySyncChange(conn, user, change)
+		default:
+			results[i] = &SyncChangeResult{Status: http.StatusBadRequest,
+				Errors: []string{ErrSyncOpInvalid.Error()}}
+		}
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+
+		results[i].Ref = change.Ref
+	}
+
+	res.Send(map[string]interface{}{"results": results}, http.StatusOK)
+}
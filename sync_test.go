@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSyncCursor(t *testing.T) {
+	id, err := ParseSyncCursor(FormatSyncCursor(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 42 {
+		t.Error("expected 42, got", id)
+	}
+
+	for _, cursor := range []string{"!", FormatSyncCursor(-1), "YWJj"} {
+		_, err = ParseSyncCursor(cursor)
+		if err != ErrSyncCursorInvalid {
+			t.Error("expected", cursor, "to be invalid, got", err)
+		}
+	}
+}
+
+func TestMergeSyncChange(t *testing.T) {
+	// Revision 2 renamed the task and revision 3 changed its notes
+	history := []*TaskChange{
+		{Action: "updated", Revision: 3, Fields: map[string]*FieldChange{"notes": {"a", "b"}}},
+		{Action: "renamed", Revision: 2, Fields: map[string]*FieldChange{"message": {"one", "two"}}},
+		{Action: "created", Revision: 1, Fields: map[string]*FieldChange{"message": {"", "one"}}},
+	}
+	task := &Task{Message: "two", Notes: "b", Tags: make([]string, 0), Revision: 3}
+
+	changed, complete := ChangedSince(history, 1, 0)
+	if !complete || len(changed) != 2 || changed["message"] != "one" || changed["notes"] != "a" {
+		t.Fatal("expected message and notes to be changed since 1, got", changed, complete)
+	}
+
+	merged, conflicts := MergeSyncChange(task, map[string]interface{}{"message": "three",
+		"notes": "b", "category": "work"}, changed, complete)
+	if len(merged) != 1 || merged["category"] != "work" {
+		t.Error("expected only category to be merged, got", merged)
+	}
+	if len(conflicts) != 1 || conflicts[0].Field != "message" || conflicts[0].Base != "one" ||
+		conflicts[0].Server != "two" || conflicts[0].Client != "three" {
+		t.Error("expected message to conflict, got", conflicts)
+	}
+
+	// A trimmed history doesn't show what changed, so every differing field conflicts
+	changed, complete = ChangedSince(history[:1], 1, 1)
+	if complete {
+		t.Fatal("expected trimmed history to be incomplete")
+	}
+
+	merged, conflicts = MergeSyncChange(task, map[string]interface{}{"category": "work"}, changed, complete)
+	if len(merged) != 0 || len(conflicts) != 1 || conflicts[0].Base != nil {
+		t.Error("expected category to conflict, got", merged, conflicts)
+	}
+}
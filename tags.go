@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/larzconwell/httpextra"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	getTags := &Route{"GetTags", "/tags", []string{"GET"}, GetTagsHandler}
+	updateTag := &Route{"UpdateTag", "/tags/{name}", []string{"PUT"}, UpdateTagHandler}
+
+	Routes = append(Routes, getTags, updateTag)
+}
+
+// ParseTags parses a comma separated list of tags, removing duplicates and empty tags.
+func ParseTags(list string) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// TagsDifference gets the tags in a that aren't in b.
+func TagsDifference(a, b []string) []string {
+	diff := make([]string, 0)
+
+	for _, tag := range a {
+		found := false
+		for _, other := range b {
+			if tag == other {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			diff = append(diff, tag)
+		}
+	}
+
+	return diff
+}
+
+// MatchTags checks if tags has all of the wanted tags, or any of them if all is false.
+func MatchTags(tags, wanted []string, all bool) bool {
+	missing := len(TagsDifference(wanted, tags))
+	if all {
+		return missing == 0
+	}
+
+	return missing < len(wanted)
+}
+
+func GetTagsHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	tags, err := conn.GetTags(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(tags, http.StatusOK)
+}
+
+func UpdateTagHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	name := strings.ToLower(mux.Vars(req)["name"])
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	newName := ParseTags(params.Get("name"))
+	if len(newName) != 1 {
+		HandleValidations(rw, req, []string{ErrTagNameInvalid.Error()}, nil)
+		return
+	}
+
+	tag, err := conn.GetTag(user.Name, name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	if tag == nil {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return
+	}
+
+	if newName[0] != name {
+		err = conn.RenameTag(user.Name, name, newName[0])
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tag, err = conn.GetTag(user.Name, newName[0])
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(tag, http.StatusOK)
+}
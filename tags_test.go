@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tags := ParseTags(" Work, home,,work ,Errands")
+	expected := []string{"work", "home", "errands"}
+
+	if !reflect.DeepEqual(tags, expected) {
+		t.Error("expected", expected, "got", tags)
+	}
+
+	if tags = ParseTags(""); tags == nil || len(tags) != 0 {
+		t.Error("expected no tags, got", tags)
+	}
+}
+
+func TestMatchTags(t *testing.T) {
+	tags := []string{"work", "home"}
+
+	if !MatchTags(tags, []string{"home", "work"}, true) || MatchTags(tags, []string{"home", "errands"}, true) {
+		t.Error("all tags were not matched")
+	}
+	if !MatchTags(tags, []string{"home", "errands"}, false) || MatchTags(tags, []string{"errands"}, false) {
+		t.Error("any tag was not matched")
+	}
+}
+
+func TestTagsDifference(t *testing.T) {
+	diff := TagsDifference([]string{"work", "home", "errands"}, []string{"home"})
+	expected := []string{"work", "errands"}
+
+	if !reflect.DeepEqual(diff, expected) {
+		t.Error("expected", expected, "got", diff)
+	}
+
+	if diff = TagsDifference([]string{"home"}, []string{"home", "work"}); len(diff) != 0 {
+		t.Error("expected no difference, got", diff)
+	}
+}
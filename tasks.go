@@ -4,17 +4,26 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/larzconwell/httpextra"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 func init() {
 	createTask := &Route{"CreateTask", "/tasks", []string{"POST"}, CreateTaskHandler}
 	getTasks := &Route{"GetTasks", "/tasks", []string{"GET"}, GetTasksHandler}
+	// Must be before GetTask, otherwise stats is matched as the id
+	getTaskStats := &Route{"GetTaskStats", "/tasks/stats", []string{"GET"}, GetTaskStatsHandler}
 	getTask := &Route{"GetTask", "/tasks/{id}", []string{"GET"}, GetTaskHandler}
 	updateTask := &Route{"UpdateTask", "/tasks/{id}", []string{"PUT"}, UpdateTaskHandler}
 	deleteTask := &Route{"DeleteTask", "/tasks/{id}", []string{"DELETE"}, DeleteTaskHandler}
+	patchTask := &Route{"PatchTask", "/tasks/{id}", []string{"PATCH"}, PatchTaskHandler}
+	moveTask := &Route{"MoveTask", "/tasks/{id}/move", []string{"POST"}, MoveTaskHandler}
+	getTaskHistory := &Route{"GetTaskHistory", "/tasks/{id}/history", []string{"GET"}, GetTaskHistoryHandler}
 
-	Routes = append(Routes, createTask, getTasks, getTask, updateTask, deleteTask)
+	Routes = append(Routes, createTask, getTasks, getTaskStats, getTask, updateTask, deleteTask, patchTask,
+		moveTask, getTaskHistory)
 }
 
 func CreateTaskHandler(rw http.ResponseWriter, req *http.Request) {
@@ -30,7 +39,8 @@ func CreateTaskHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	task := &Task{Conn: conn, Message: params.Get("message"), Category: params.Get("category"), User: user}
+	task := &Task{Conn: conn, User: user}
+	setTaskParams(task, params)
 	errs, err := task.Validate()
 	ok = HandleValidations(rw, req, errs, err)
 	if !ok {
@@ -38,7 +48,13 @@ func CreateTaskHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 	res := &httpextra.Response{ContentTypes, rw, req}
 
-	err = task.Save(true)
+	// Ids can't be generated inside the transaction since replies are queued
+	task.ID, err = conn.NextTaskID(user.Name)
+	if err == nil {
+		err = conn.Transaction(func() error {
+			return task.Save(false)
+		})
+	}
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
@@ -56,13 +72,77 @@ func GetTasksHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	res := &httpextra.Response{ContentTypes, rw, req}
+	query := req.URL.Query()
+
+	var (
+		tasks []*Task
+		err   error
+	)
+
+	tags := ParseTags(strings.Join(query["tag"], ","))
+	all := query.Get("match") != "any"
+
+	// Tasks assigned to the authenticated user are looked up directly
+	assignee := query.Get("assignee")
+	if assignee == "me" {
+		assignee = user.Name
+	}
+
+	if assignee == user.Name {
+		assigned, err := conn.GetAssignedTasks(user.Name)
+		if err != nil {
+			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+
+		tasks = make([]*Task, 0, len(assigned))
+		for _, task := range assigned {
+			if len(tags) <= 0 || MatchTags(task.Tags, tags, all) {
+				tasks = append(tasks, task)
+			}
+		}
+
+		renderNotes(req, tasks...)
+		res.Send(tasks, http.StatusOK)
+		return
+	}
 
-	tasks, err := conn.GetTasks(user.Name)
+	if len(tags) > 0 {
+		tasks, err = conn.GetTasksByTags(user.Name, tags, all)
+	} else {
+		tasks, err = conn.GetTasks(user.Name)
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	// Include tasks from lists shared with the user
+	shared, err := conn.GetSharedTasks(user.Name)
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
+	for _, task := range shared {
+		if len(tags) <= 0 || MatchTags(task.Tags, tags, all) {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Sort(tasksByPosition(tasks))
+
+	if assignee != "" {
+		assigned := make([]*Task, 0)
+		for _, task := range tasks {
+			if task.Assignee == assignee {
+				assigned = append(assigned, task)
+			}
+		}
+
+		tasks = assigned
+	}
+
+	renderNotes(req, tasks...)
 	res.Send(tasks, http.StatusOK)
 }
 
@@ -87,7 +167,15 @@ func GetTaskHandler(rw http.ResponseWriter, req *http.Request) {
 		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
 		return
 	}
+	etag := renderETag(req, task.Revision)
+	rw.Header().Set("ETag", etag)
 
+	if MatchETag(req.Header.Get("If-None-Match"), etag, true) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	renderNotes(req, task)
 	res.Send(task, http.StatusOK)
 }
 
@@ -96,10 +184,8 @@ func UpdateTaskHandler(rw http.ResponseWriter, req *http.Request) {
 	if !ok {
 		return
 	}
-	_, messageGiven := params["message"]
-	_, categoryGiven := params["category"]
-	_, completeGiven := params["complete"]
 	id := mux.Vars(req)["id"]
+	ifMatch := req.Header.Get("If-Match")
 	conn := Pool.Get()
 	defer conn.Close()
 
@@ -109,53 +195,322 @@ func UpdateTaskHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 	res := &httpextra.Response{ContentTypes, rw, req}
 
-	task, err := conn.GetTask(user.Name, id)
+	task, ok := getTaskIfMatch(conn, res, user, id, ifMatch)
+	if !ok {
+		return
+	}
+
+	if !setTaskParams(task, params) {
+		rw.Header().Set("ETag", ETag(task.Revision))
+		res.Send(task, http.StatusOK)
+		return
+	}
+	errs, err := task.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	err = conn.Transaction(func() error {
+		return task.Save(false)
+	})
+	if err == ErrTransactionAborted {
+		status := abortedStatus(ifMatch)
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
-	if task == nil {
-		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+	rw.Header().Set("ETag", ETag(task.Revision))
+	res.Send(task, http.StatusOK)
+}
+
+func PatchTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
 		return
 	}
-	task.User = user
+	patch, ok := ParsePatch(rw, req)
+	if !ok {
+		return
+	}
+	id := mux.Vars(req)["id"]
+	ifMatch := req.Header.Get("If-Match")
+	res := &httpextra.Response{ContentTypes, rw, req}
 
-	if !messageGiven && !categoryGiven && !completeGiven {
-		res.Send(task, http.StatusOK)
+	task, ok := getTaskIfMatch(conn, res, user, id, ifMatch)
+	if !ok {
+		return
+	}
+
+	errs := task.Patch(patch)
+	ok = HandleValidations(rw, req, errs, nil)
+	if !ok {
+		return
+	}
+
+	errs, err := task.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	err = conn.Transaction(func() error {
+		return task.Save(false)
+	})
+	if err == ErrTransactionAborted {
+		status := abortedStatus(ifMatch)
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("ETag", ETag(task.Revision))
+	res.Send(task, http.StatusOK)
+}
+
+func DeleteTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	id := mux.Vars(req)["id"]
+	ifMatch := req.Header.Get("If-Match")
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	task, ok := getTaskIfMatch(conn, res, user, id, ifMatch)
+	if !ok {
+		return
+	}
+
+	// Tasks are moved to the trash unless a hard delete is asked for
+	hard, _ := strconv.ParseBool(req.URL.Query().Get("hard"))
+	err := conn.Transaction(func() error {
+		if hard {
+			return task.Purge()
+		}
+
+		return task.Trash()
+	})
+	if err == ErrTransactionAborted {
+		status := abortedStatus(ifMatch)
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
 		return
 	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(task, http.StatusOK)
+}
+
+// setTaskParams sets the task fields given in params, returning false if none were given.
+func setTaskParams(task *Task, params url.Values) bool {
+	given := false
 
-	if messageGiven {
+	if _, ok := params["message"]; ok {
 		task.Message = params.Get("message")
+		given = true
+	}
+	if _, ok := params["notes"]; ok {
+		task.Notes = params.Get("notes")
+		given = true
 	}
-	if categoryGiven {
+	if _, ok := params["category"]; ok {
 		task.Category = params.Get("category")
+		given = true
 	}
-	if completeGiven {
+	if _, ok := params["complete"]; ok {
 		complete, err := strconv.ParseBool(params.Get("complete"))
-		if err != nil {
-			complete = false
+		if err == nil {
+			task.Complete = complete
 		}
 
-		task.Complete = complete
+		task.completeInvalid = err != nil
+		given = true
 	}
-	errs, err := task.Validate()
-	ok = HandleValidations(rw, req, errs, err)
+	if _, ok := params["priority"]; ok {
+		task.Priority = strings.ToUpper(params.Get("priority"))
+		given = true
+	}
+	if _, ok := params["remind"]; ok {
+		task.Remind = params.Get("remind")
+		given = true
+	}
+	if _, ok := params["tags"]; ok {
+		task.Tags = ParseTags(params.Get("tags"))
+		given = true
+	}
+	if _, ok := params["list"]; ok {
+		task.List = params.Get("list")
+		given = true
+	}
+	if _, ok := params["assignee"]; ok {
+		task.Assignee = params.Get("assignee")
+		given = true
+	}
+
+	return given
+}
+
+// renderNotes renders the tasks notes as HTML if the render query is html.
+func renderNotes(req *http.Request, tasks ...*Task) {
+	if req.URL.Query().Get("render") != "html" {
+		return
+	}
+
+	for _, task := range tasks {
+		task.NotesHTML = RenderMarkdown(task.Notes)
+	}
+}
+
+// htmlETag gets the entity tag for a revision with rendered notes, which is a different
+// representation of the same revision.
+func htmlETag(revision int) string {
+	return "\"" + strconv.Itoa(revision) + "-html\""
+}
+
+// renderETag gets the entity tag for a task response given its render query.
+func renderETag(req *http.Request, revision int) string {
+	if req.URL.Query().Get("render") == "html" {
+		return htmlETag(revision)
+	}
+
+	return ETag(revision)
+}
+
+// matchRevision checks if an If-Match value matches a revision in either representation.
+func matchRevision(ifMatch string, revision int) bool {
+	return MatchETag(ifMatch, ETag(revision), false) || MatchETag(ifMatch, htmlETag(revision), false)
+}
+
+// getTaskIfMatch gets a task for modification, responding if it's missing or if the If-Match
+// value doesn't match its revision. The task is watched so changes made before saving are
+// caught, and the revision it's saved with is the next one.
+func getTaskIfMatch(conn *Conn, res *httpextra.Response, user *User, id, ifMatch string) (*Task, bool) {
+	err := conn.WatchTask(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	task, err := conn.GetTask(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if task == nil {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return nil, false
+	}
+	task.User = user
+
+	if ifMatch != "" && !matchRevision(ifMatch, task.Revision) {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusPreconditionFailed)},
+			http.StatusPreconditionFailed)
+		return nil, false
+	}
+
+	return task, true
+}
+
+// abortedStatus gets the status for a save that failed since the task changed, which is a
+// failed precondition if an If-Match value was given.
+func abortedStatus(ifMatch string) int {
+	if ifMatch != "" {
+		return http.StatusPreconditionFailed
+	}
+
+	return http.StatusConflict
+}
+
+func MoveTaskHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
 	if !ok {
 		return
 	}
+	_, beforeGiven := params["before"]
+	_, afterGiven := params["after"]
+	id := mux.Vars(req)["id"]
+	ifMatch := req.Header.Get("If-Match")
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	if beforeGiven == afterGiven {
+		HandleValidations(rw, req, []string{ErrTaskMoveTargetInvalid.Error()}, nil)
+		return
+	}
+	targetID := params.Get("before")
+	if afterGiven {
+		targetID = params.Get("after")
+	}
 
-	err = task.Save(false)
+	task, ok := getTaskIfMatch(conn, res, user, id, ifMatch)
+	if !ok {
+		return
+	}
+
+	target, err := conn.GetTask(user.Name, targetID)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	if target == nil || target.ID == task.ID {
+		HandleValidations(rw, req, []string{ErrTaskMoveTargetInvalid.Error()}, nil)
+		return
+	}
+	if target.Category != task.Category {
+		HandleValidations(rw, req, []string{ErrTaskMoveCategory.Error()}, nil)
+		return
+	}
+
+	// The move records its change itself so they're saved together, the event is published
+	// once the new position is known
+	err = conn.Transaction(func() error {
+		return task.Move(target, afterGiven)
+	})
+	if err == ErrTransactionAborted {
+		status := abortedStatus(ifMatch)
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = task.PublishEvent(user.Name, EventTaskMoved, task)
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
+	rw.Header().Set("ETag", ETag(task.Revision))
 	res.Send(task, http.StatusOK)
 }
 
-func DeleteTaskHandler(rw http.ResponseWriter, req *http.Request) {
+func GetTaskHistoryHandler(rw http.ResponseWriter, req *http.Request) {
 	conn := Pool.Get()
 	defer conn.Close()
 
@@ -176,13 +531,12 @@ func DeleteTaskHandler(rw http.ResponseWriter, req *http.Request) {
 		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
 		return
 	}
-	task.User = user
 
-	err = task.Delete()
+	history, err := conn.GetTaskHistory(user.Name, strconv.Itoa(task.ID))
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
-	res.Send(task, http.StatusOK)
+	res.Send(history, http.StatusOK)
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// todoDate is the layout for dates in todo.txt files.
+const todoDate = "2006-01-02"
+
+// MarshalTodo marshals tasks to the todo.txt format, errors are written as text.
+func MarshalTodo(data interface{}) ([]byte, error) {
+	out := ""
+
+	switch data := data.(type) {
+	case *Task:
+		out = FormatTodo(data) + "\n"
+	case []*Task:
+		for _, task := range data {
+			out += FormatTodo(task) + "\n"
+		}
+	default:
+		return marshalText(data)
+	}
+
+	return []byte(out), nil
+}
+
+// FormatTodo formats a task as a todo.txt line. The category is written as a
+// project, tags as contexts, and the reminder as a due date.
+func FormatTodo(task *Task) string {
+	parts := make([]string, 0)
+	created := todoDateOf(task.Created)
+
+	if task.Complete {
+		parts = append(parts, "x")
+
+		// The creation date can only be given after the completion date
+		if completed := todoDateOf(task.Completed); completed != "" {
+			parts = append(parts, completed)
+			if created != "" {
+				parts = append(parts, created)
+			}
+		}
+
+		parts = append(parts, task.Message)
+		if task.Priority != "" {
+			parts = append(parts, "pri:"+task.Priority)
+		}
+	} else {
+		if task.Priority != "" {
+			parts = append(parts, "("+task.Priority+")")
+		}
+		if created != "" {
+			parts = append(parts, created)
+		}
+
+		parts = append(parts, task.Message)
+	}
+
+	if task.Category != "" {
+		parts = append(parts, "+"+todoWord(task.Category))
+	}
+	for _, tag := range task.Tags {
+		parts = append(parts, "@"+todoWord(tag))
+	}
+
+	if task.Remind != "" {
+		remind, err := time.Parse(time.RFC3339, task.Remind)
+		if err == nil && remind.Equal(remind.Truncate(24*time.Hour)) {
+			parts = append(parts, "due:"+remind.UTC().Format(todoDate))
+		} else {
+			parts = append(parts, "due:"+task.Remind)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// ParseTodo parses a todo.txt line into a task. The first project is used as
+// the category and contexts are used as tags.
+func ParseTodo(line string) *Task {
+	task := &Task{Tags: make([]string, 0)}
+	words := strings.Fields(line)
+
+	if len(words) > 0 && words[0] == "x" {
+		task.Complete = true
+		words = words[1:]
+	}
+
+	if len(words) > 0 && len(words[0]) == 3 && words[0][0] == '(' && words[0][2] == ')' &&
+		words[0][1] >= 'A' && words[0][1] <= 'Z' {
+		task.Priority = words[0][1:2]
+		words = words[1:]
+	}
+
+	// Completed tasks have a completion date before the creation date
+	dates := make([]string, 0)
+	max := 1
+	if task.Complete {
+		max = 2
+	}
+
+	for i := 0; i < max && len(words) > 0; i++ {
+		date, err := time.Parse(todoDate, words[0])
+		if err != nil {
+			break
+		}
+
+		dates = append(dates, date.Format(time.RFC3339))
+		words = words[1:]
+	}
+
+	if task.Complete && len(dates) > 0 {
+		task.Completed = dates[0]
+		dates = dates[1:]
+	}
+	if len(dates) > 0 {
+		task.Created = dates[0]
+	}
+
+	message := make([]string, 0)
+	tags := make([]string, 0)
+	for _, word := range words {
+		switch {
+		case len(word) > 1 && word[0] == '+':
+			if task.Category == "" {
+				task.Category = word[1:]
+			}
+		case len(word) > 1 && word[0] == '@':
+			tags = append(tags, word[1:])
+		case strings.HasPrefix(word, "pri:") && len(word) == 5:
+			task.Priority = strings.ToUpper(word[4:])
+		case strings.HasPrefix(word, "due:") && len(word) > 4:
+			task.Remind = parseTodoDue(word[4:])
+		default:
+			message = append(message, word)
+		}
+	}
+
+	task.Message = strings.Join(message, " ")
+	task.Tags = ParseTags(strings.Join(tags, ","))
+	return task
+}
+
+// parseTodoDue parses a due date as an RFC3339 time, dates are midnight UTC.
+func parseTodoDue(due string) string {
+	date, err := time.Parse(todoDate, due)
+	if err != nil {
+		return due
+	}
+
+	return date.Format(time.RFC3339)
+}
+
+// todoDateOf formats an RFC3339 time as a todo.txt date, returning an empty
+// string if it's invalid.
+func todoDateOf(value string) string {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return ""
+	}
+
+	return date.UTC().Format(todoDate)
+}
+
+// todoWord replaces spaces so a value can be written as a single word.
+func todoWord(value string) string {
+	return strings.Join(strings.Fields(value), "-")
+}
+
+// ReadTodo reads the tasks in a todo.txt file, skipping blank lines.
+func ReadTodo(r io.Reader) ([]*Task, error) {
+	tasks := make([]*Task, 0)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		tasks = append(tasks, ParseTodo(line))
+	}
+
+	return tasks, scanner.Err()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTodo(t *testing.T) {
+	task := ParseTodo("x 2026-10-19 2026-10-01 Buy milk +Home @shop @Food due:2026-10-20 pri:B")
+
+	if !task.Complete {
+		t.Error("completion was not parsed")
+	}
+	if task.Message != "Buy milk" {
+		t.Error("message was not parsed, got", task.Message)
+	}
+	if task.Category != "Home" {
+		t.Error("project was not parsed as the category")
+	}
+	if len(task.Tags) != 2 || task.Tags[0] != "shop" || task.Tags[1] != "food" {
+		t.Error("contexts were not parsed as tags")
+	}
+	if task.Priority != "B" {
+		t.Error("priority was not parsed")
+	}
+	if task.Remind != "2026-10-20T00:00:00Z" {
+		t.Error("due date was not parsed as the reminder, got", task.Remind)
+	}
+	if task.Completed != "2026-10-19T00:00:00Z" || task.Created != "2026-10-01T00:00:00Z" {
+		t.Error("completion and creation dates were not parsed")
+	}
+}
+
+func TestFormatTodo(t *testing.T) {
+	tasks := []*Task{
+		{Message: "Call mom", Priority: "A", Category: "family time", Tags: []string{"phone"}},
+		{Message: "Buy milk", Complete: true, Remind: "2026-10-20T00:00:00Z", Tags: []string{}},
+		{Message: "Pay rent", Complete: true, Priority: "B", Created: "2026-10-01T08:00:00Z",
+			Completed: "2026-10-03T00:00:00Z"},
+	}
+	lines := []string{
+		"(A) Call mom +family-time @phone",
+		"x Buy milk due:2026-10-20",
+		"x 2026-10-03 2026-10-01 Pay rent pri:B",
+	}
+
+	for i, task := range tasks {
+		line := FormatTodo(task)
+		if line != lines[i] {
+			t.Error("expected", lines[i], "got", line)
+		}
+
+		parsed := ParseTodo(line)
+		if parsed.Message != task.Message || parsed.Priority != task.Priority ||
+			parsed.Complete != task.Complete || parsed.Remind != task.Remind {
+			t.Error("task did not round trip through", line)
+		}
+	}
+}
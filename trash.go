@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/larzconwell/httpextra"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// purgeBatch is the most trashed tasks purged on a single tick.
+const purgeBatch = 100
+
+func init() {
+	getTrash := &Route{"GetTrash", "/trash", []string{"GET"}, GetTrashHandler}
+	restoreTrash := &Route{"RestoreTrash", "/trash/{id}/restore", []string{"POST"}, RestoreTrashHandler}
+	deleteTrash := &Route{"DeleteTrash", "/trash/{id}", []string{"DELETE"}, DeleteTrashHandler}
+
+	Routes = append(Routes, getTrash, restoreTrash, deleteTrash)
+}
+
+// PurgeTrashJob permanently deletes tasks that have been in the trash longer than the
+// configured retention, with no retention they're kept.
+func PurgeTrashJob(conn *Conn) error {
+	if Config.TrashRetention <= 0 {
+		return nil
+	}
+
+	expired, err := conn.GetExpiredTrash(time.Now().Add(-Config.TrashRetention), purgeBatch)
+	if err != nil {
+		return err
+	}
+
+	// Each task is watched so one restored or purged elsewhere meanwhile is skipped, it's
+	// only unqueued once it's deleted
+	for _, item := range expired {
+		i := strings.LastIndex(item, ":")
+		name, id := item[:i], item[i+1:]
+
+		err = conn.WatchTask(name, id)
+		if err != nil {
+			return err
+		}
+
+		task, err := conn.GetTrashTask(name, id)
+		if err != nil {
+			return err
+		}
+
+		if task != nil {
+			task.User = &User{Name: name}
+		}
+
+		err = conn.Transaction(func() error {
+			if task == nil {
+				return conn.UnqueueTrash(item)
+			}
+
+			return task.Delete()
+		})
+		if err != nil && err != ErrTransactionAborted {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetTrashHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	tasks, err := conn.GetTrash(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(tasks, http.StatusOK)
+}
+
+func RestoreTrashHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	id := mux.Vars(req)["id"]
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	// The task is watched so it's not restored if it's changed meanwhile
+	err := conn.WatchTask(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	task, err := conn.GetTrashTask(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	if task == nil {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return
+	}
+	task.User = user
+
+	err = conn.Transaction(task.Restore)
+	if err == ErrTransactionAborted {
+		status := abortedStatus("")
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(task, http.StatusOK)
+}
+
+func DeleteTrashHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	id := mux.Vars(req)["id"]
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	// The task is watched so it's not purged if it's changed meanwhile
+	err := conn.WatchTask(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	task, err := conn.GetTrashTask(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	if task == nil {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return
+	}
+	task.User = user
+
+	err = conn.Transaction(task.Purge)
+	if err == ErrTransactionAborted {
+		status := abortedStatus("")
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(task, http.StatusOK)
+}
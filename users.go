@@ -3,15 +3,18 @@ package main
 import (
 	"github.com/larzconwell/httpextra"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 func init() {
 	createUser := &Route{"CreateUser", "/user", []string{"POST"}, CreateUserHandler}
 	getUser := &Route{"GetUser", "/user", []string{"GET"}, GetUserHandler}
 	updateUser := &Route{"UpdateUser", "/user", []string{"PUT"}, UpdateUserHandler}
+	patchUser := &Route{"PatchUser", "/user", []string{"PATCH"}, PatchUserHandler}
 	deleteUser := &Route{"DeleteUser", "/user", []string{"DELETE"}, DeleteUserHandler}
 
-	Routes = append(Routes, createUser, getUser, updateUser, deleteUser)
+	Routes = append(Routes, createUser, getUser, updateUser, patchUser, deleteUser)
 }
 
 func CreateUserHandler(rw http.ResponseWriter, req *http.Request) {
@@ -24,7 +27,8 @@ func CreateUserHandler(rw http.ResponseWriter, req *http.Request) {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	user := &User{conn, params.Get("name"), params.Get("password")}
+	user := &User{Conn: conn, Name: params.Get("name"), Password: params.Get("password"),
+		Email: params.Get("email")}
 	errs, err := user.Validate(true)
 	ok = HandleValidations(rw, req, errs, err)
 	if !ok {
@@ -54,7 +58,8 @@ func CreateUserHandler(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		activity := &Activity{Conn: conn, Message: "Created device " + device.Name, User: user}
+		activity := &Activity{Conn: conn, Type: ActivityDeviceCreated, Message: "Created device " + device.Name,
+			Meta: map[string]string{"name": device.Name}, User: user}
 		err = activity.Save()
 		if err != nil {
 			res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
@@ -109,6 +114,8 @@ func UpdateUserHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	_, passwordGiven := params["password"]
+	_, emailGiven := params["email"]
+	_, mutedGiven := params["muted"]
 	conn := Pool.Get()
 	defer conn.Close()
 
@@ -118,25 +125,95 @@ func UpdateUserHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 	res := &httpextra.Response{ContentTypes, rw, req}
 
-	if !passwordGiven {
+	if !passwordGiven && !emailGiven && !mutedGiven {
 		res.Send(user, http.StatusOK)
 		return
 	}
 
-	user.Password = params.Get("password")
+	if passwordGiven {
+		user.Password = params.Get("password")
+	}
+	if emailGiven {
+		user.Email = params.Get("email")
+	}
+	if mutedGiven {
+		user.Muted = ParseTags(params.Get("muted"))
+	}
 	errs, err := user.Validate(false)
 	ok = HandleValidations(rw, req, errs, err)
 	if !ok {
 		return
 	}
 
-	err = user.Save(true)
+	err = user.Save(passwordGiven)
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
-	activity := &Activity{Conn: conn, Message: "Updated user", User: user}
+	fields := make([]string, 0)
+	if emailGiven {
+		fields = append(fields, "email")
+	}
+	if mutedGiven {
+		fields = append(fields, "muted")
+	}
+	if passwordGiven {
+		fields = append(fields, "password")
+	}
+
+	activity := &Activity{Conn: conn, Type: ActivityUserUpdated, Message: "Updated user",
+		Meta: map[string]string{"fields": strings.Join(fields, ",")}, Device: user.Device, User: user}
+	err = activity.Save()
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(user, http.StatusOK)
+}
+
+func PatchUserHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	patch, ok := ParsePatch(rw, req)
+	if !ok {
+		return
+	}
+	_, passwordGiven := patch["password"]
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	errs := user.Patch(patch)
+	ok = HandleValidations(rw, req, errs, nil)
+	if !ok {
+		return
+	}
+
+	errs, err := user.Validate(false)
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	err = user.Save(passwordGiven)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	activity := &Activity{Conn: conn, Type: ActivityUserUpdated, Message: "Updated user",
+		Meta: map[string]string{"fields": strings.Join(fields, ",")}, Device: user.Device, User: user}
 	err = activity.Save()
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
@@ -156,7 +233,29 @@ func DeleteUserHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 	res := &httpextra.Response{ContentTypes, rw, req}
 
-	err := conn.DeleteActivities(user.Name)
+	err := conn.UnassignTasks(user.Name)
+	if err == ErrTransactionAborted {
+		status := abortedStatus("")
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = conn.DeleteLists(user.Name)
+	if err == ErrTransactionAborted {
+		status := abortedStatus("")
+		res.Send(map[string]string{"error": http.StatusText(status)}, status)
+		return
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = conn.DeleteActivities(user.Name)
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 		return
@@ -174,6 +273,24 @@ func DeleteUserHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	err = conn.DeleteWebhooks(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = conn.DeleteChanges(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	err = conn.DeleteEvents(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
 	err = user.Delete()
 	if err != nil {
 		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
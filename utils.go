@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
 	"github.com/larzconwell/httpextra"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Validations validates a set of tests, returning a slice of validation errors if any.
@@ -55,3 +61,72 @@ func HandleValidations(rw http.ResponseWriter, req *http.Request, errs []string,
 
 	return true
 }
+
+// markdownPolicy sanitizes rendered Markdown, allowing the HTML user content needs.
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// RenderMarkdown renders Markdown as sanitized HTML.
+func RenderMarkdown(markdown string) string {
+	return string(markdownPolicy.SanitizeBytes(blackfriday.MarkdownCommon([]byte(markdown))))
+}
+
+// ETag formats a revision as an entity tag.
+func ETag(revision int) string {
+	return "\"" + strconv.Itoa(revision) + "\""
+}
+
+// MatchETag checks if an If-Match or If-None-Match header value matches an entity tag. If-Match
+// uses the strong comparison, so weak tags never match it, and If-None-Match uses the weak one.
+func MatchETag(header, etag string, weak bool) bool {
+	for _, item := range strings.Split(header, ",") {
+		item = strings.TrimSpace(item)
+		if weak {
+			item = strings.TrimPrefix(item, "W/")
+		}
+
+		if item == "*" || item == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bodyTooLarge checks if reading a request body failed because it's over its limit.
+func bodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// marshalText marshals error responses as key: value lines, for content types that
+// can only represent specific data.
+func marshalText(data interface{}) ([]byte, error) {
+	out := ""
+
+	switch data := data.(type) {
+	case map[string]string:
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			out += key + ": " + data[key] + "\n"
+		}
+	case map[string]interface{}:
+		// Validation errors are the only other values sent
+		errs, ok := data["errors"].([]string)
+		if !ok {
+			return nil, ErrContentTypeUnsupported
+		}
+
+		for _, e := range errs {
+			out += "error: " + e + "\n"
+		}
+	default:
+		return nil, ErrContentTypeUnsupported
+	}
+
+	return []byte(out), nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	html := RenderMarkdown("# Groceries\n\n- *milk*\n\n<script>alert(1)</script>[x](javascript:alert(1))")
+
+	if !strings.Contains(html, "<h1>Groceries</h1>") || !strings.Contains(html, "<em>milk</em>") {
+		t.Error("markdown was not rendered, got", html)
+	}
+	if strings.Contains(html, "<script>") || strings.Contains(html, "javascript:") {
+		t.Error("html was not sanitized, got", html)
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	etag := ETag(2)
+
+	if !MatchETag(`"1", "2"`, etag, false) || !MatchETag("*", etag, false) {
+		t.Error("If-Match did not match", etag)
+	}
+	if MatchETag(`W/"2"`, etag, false) {
+		t.Error("If-Match matched a weak tag")
+	}
+	if !MatchETag(`W/"2"`, etag, true) {
+		t.Error("If-None-Match did not match a weak tag")
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/larzconwell/httpextra"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// webhookBatch is the most deliveries claimed and waiting to be sent at once.
+const webhookBatch = 20
+
+// webhookWorkers is how many deliveries are sent at once.
+const webhookWorkers = 4
+
+// webhookTimeout is how long a webhook has to respond to a delivery.
+const webhookTimeout = 5 * time.Second
+
+// webhookLease is how long claimed deliveries are held, long enough for a full batch to
+// time out.
+const webhookLease = 2 * webhookBatch * webhookTimeout
+
+func init() {
+	createWebhook := &Route{"CreateWebhook", "/webhooks", []string{"POST"}, CreateWebhookHandler}
+	getWebhooks := &Route{"GetWebhooks", "/webhooks", []string{"GET"}, GetWebhooksHandler}
+	getWebhook := &Route{"GetWebhook", "/webhooks/{id}", []string{"GET"}, GetWebhookHandler}
+	updateWebhook := &Route{"UpdateWebhook", "/webhooks/{id}", []string{"PUT"}, UpdateWebhookHandler}
+	deleteWebhook := &Route{"DeleteWebhook", "/webhooks/{id}", []string{"DELETE"}, DeleteWebhookHandler}
+	getDeliveries := &Route{"GetDeliveries", "/webhooks/{id}/deliveries", []string{"GET"},
+		GetDeliveriesHandler}
+
+	Routes = append(Routes, createWebhook, getWebhooks, getWebhook, updateWebhook, deleteWebhook,
+		getDeliveries)
+}
+
+// NewWebhookClient creates the client deliveries are sent with. Unless private addresses are
+// allowed, connections to loopback, private, and link-local addresses are refused so webhooks
+// can't reach internal services.
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !Config.WebhookAllowPrivate {
+		dialer.Control = publicAddress
+	}
+
+	// No proxy is used, it would be dialed instead of the webhook and bypass the address check
+	transport := &http.Transport{DialContext: dialer.DialContext}
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+// publicAddress refuses connections to addresses that aren't public.
+func publicAddress(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return ErrWebhookAddressPrivate
+	}
+
+	return nil
+}
+
+// SignWebhook signs a delivery body with the webhooks secret, as the hex HMAC-SHA256.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts an event to the webhook, signing it if the webhook has a secret.
+func (webhook *Webhook) Send(client *http.Client, queued *QueuedDelivery) *Delivery {
+	start := time.Now()
+	delivery := &Delivery{EventID: queued.Event.ID, Event: queued.Event.Type, Attempt: queued.Attempt + 1,
+		Time: start.Format(time.RFC3339)}
+
+	body, err := json.Marshal(map[string]interface{}{"id": queued.Event.ID, "event": queued.Event.Type,
+		"user": queued.User, "data": json.RawMessage(queued.Event.Data)})
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "moln-webhook")
+	req.Header.Set("X-Moln-Event", queued.Event.Type)
+	req.Header.Set("X-Moln-Delivery", strconv.FormatInt(queued.Event.ID, 10))
+	if webhook.Secret != "" {
+		req.Header.Set("X-Moln-Signature", "sha256="+SignWebhook(webhook.Secret, body))
+	}
+
+	res, err := client.Do(req)
+	delivery.Duration = time.Since(start).Seconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer res.Body.Close()
+
+	// Read some of the body so the connection can be reused
+	io.CopyN(ioutil.Discard, res.Body, 4096)
+
+	delivery.Status = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		delivery.Error = ErrWebhookStatus.Error()
+	}
+
+	return delivery
+}
+
+// WebhookSender sends queued events to webhooks from a fixed number of workers, so slow
+// webhooks don't hold up the scheduler. Failed attempts are retried with exponential backoff
+// up to the configured attempts.
+type WebhookSender struct {
+	Client *http.Client
+	Logger *log.Logger
+	queue  chan *QueuedDelivery
+	stop   chan bool
+}
+
+// NewWebhookSender creates a sender that logs delivery errors to the given logger.
+func NewWebhookSender(client *http.Client, logger *log.Logger) *WebhookSender {
+	return &WebhookSender{Client: client, Logger: logger, queue: make(chan *QueuedDelivery, webhookBatch),
+		stop: make(chan bool)}
+}
+
+// Start runs the workers in the background until stopped.
+func (sender *WebhookSender) Start() {
+	for i := 0; i < webhookWorkers; i++ {
+		go sender.work()
+	}
+}
+
+// Stop stops the workers once they've sent their current deliveries. Deliveries still
+// waiting are sent again once their lease expires.
+func (sender *WebhookSender) Stop() {
+	close(sender.stop)
+}
+
+// Job creates a job that claims deliveries for the workers, only as many as there's room
+// for so claiming never waits on them.
+func (sender *WebhookSender) Job() Job {
+	return func(conn *Conn) error {
+		room := cap(sender.queue) - len(sender.queue)
+		if room <= 0 {
+			return nil
+		}
+
+		deliveries, err := conn.ClaimWebhookDeliveries(time.Now(), webhookLease, room)
+		if err != nil {
+			return err
+		}
+
+		for _, queued := range deliveries {
+			sender.queue <- queued
+		}
+
+		return nil
+	}
+}
+
+// work sends claimed deliveries until stopped, each with its own connection.
+func (sender *WebhookSender) work() {
+	for {
+		select {
+		case queued := <-sender.queue:
+			conn := Pool.Get()
+			err := deliverWebhook(conn, sender.Client, queued)
+			conn.Close()
+			if err != nil {
+				sender.Logger.Println(err)
+			}
+		case <-sender.stop:
+			return
+		}
+	}
+}
+
+// deliverWebhook sends a claimed delivery and logs it, requeuing it if it failed and may
+// be retried. Deliveries for webhooks that are gone, disabled, or not given the event
+// are dropped.
+func deliverWebhook(conn *Conn, client *http.Client, queued *QueuedDelivery) error {
+	webhook, err := conn.GetWebhook(queued.User, queued.Webhook)
+	if err != nil {
+		return err
+	}
+
+	if webhook == nil || webhook.Disabled || !webhook.Matches(queued.Event.Type) {
+		return conn.AckWebhookDelivery(queued)
+	}
+
+	// Deliveries for users that have since been deleted are dropped
+	webhook.User, err = conn.GetUser(queued.User)
+	if err != nil {
+		return err
+	}
+	if webhook.User == nil {
+		return conn.AckWebhookDelivery(queued)
+	}
+
+	delivery := webhook.Send(client, queued)
+	err = webhook.Record(delivery)
+	if err != nil {
+		return err
+	}
+
+	if delivery.Error != "" && !webhook.Disabled && delivery.Attempt < Config.WebhookAttempts {
+		backoff := Config.WebhookBackoff * time.Duration(1<<uint(queued.Attempt))
+		return conn.RetryWebhookDelivery(queued, time.Now().Add(backoff))
+	}
+
+	return conn.AckWebhookDelivery(queued)
+}
+
+// getWebhook gets a users webhook, responding if it doesn't exist.
+func getWebhook(conn *Conn, res *httpextra.Response, user *User, id string) *Webhook {
+	webhook, err := conn.GetWebhook(user.Name, id)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return nil
+	}
+
+	if webhook == nil {
+		res.Send(map[string]string{"error": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
+		return nil
+	}
+	webhook.User = user
+
+	return webhook
+}
+
+func CreateWebhookHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+
+	webhook := &Webhook{Conn: conn, URL: params.Get("url"), Secret: params.Get("secret"),
+		Events: ParseTags(params.Get("events")), User: user}
+	errs, err := webhook.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	err = webhook.Save(true)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(webhook, http.StatusOK)
+}
+
+func GetWebhooksHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	webhooks, err := conn.GetWebhooks(user.Name)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(webhooks, http.StatusOK)
+}
+
+func GetWebhookHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	webhook := getWebhook(conn, res, user, mux.Vars(req)["id"])
+	if webhook == nil {
+		return
+	}
+
+	res.Send(webhook, http.StatusOK)
+}
+
+func UpdateWebhookHandler(rw http.ResponseWriter, req *http.Request) {
+	params, ok := httpextra.ParseForm(ContentTypes, rw, req)
+	if !ok {
+		return
+	}
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	webhook := getWebhook(conn, res, user, mux.Vars(req)["id"])
+	if webhook == nil {
+		return
+	}
+
+	if _, ok := params["url"]; ok {
+		webhook.URL = params.Get("url")
+	}
+	if _, ok := params["secret"]; ok {
+		webhook.Secret = params.Get("secret")
+	}
+	if _, ok := params["events"]; ok {
+		webhook.Events = ParseTags(params.Get("events"))
+	}
+
+	// Enabling a disabled webhook starts counting failures again
+	reset := false
+	if _, ok := params["disabled"]; ok {
+		webhook.Disabled, _ = strconv.ParseBool(params.Get("disabled"))
+		reset = !webhook.Disabled
+	}
+
+	errs, err := webhook.Validate()
+	ok = HandleValidations(rw, req, errs, err)
+	if !ok {
+		return
+	}
+
+	err = webhook.Save(false)
+	if err == nil && reset {
+		err = webhook.ResetFailures()
+	}
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(webhook, http.StatusOK)
+}
+
+func DeleteWebhookHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	webhook := getWebhook(conn, res, user, mux.Vars(req)["id"])
+	if webhook == nil {
+		return
+	}
+
+	err := webhook.Delete()
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(webhook, http.StatusOK)
+}
+
+func GetDeliveriesHandler(rw http.ResponseWriter, req *http.Request) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	user := Authenticate(conn, rw, req)
+	if user == nil {
+		return
+	}
+	res := &httpextra.Response{ContentTypes, rw, req}
+
+	webhook := getWebhook(conn, res, user, mux.Vars(req)["id"])
+	if webhook == nil {
+		return
+	}
+
+	deliveries, err := conn.GetDeliveries(user.Name, webhook.ID)
+	if err != nil {
+		res.Send(map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	res.Send(deliveries, http.StatusOK)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookSend(t *testing.T) {
+	var (
+		body      []byte
+		signature string
+	)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ = ioutil.ReadAll(req.Body)
+		signature = req.Header.Get("X-Moln-Signature")
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL, Secret: "shh"}
+	queued := &QueuedDelivery{Attempt: 1, User: "larz", Webhook: "abc",
+		Event: &Event{7, "task.created", `{"id":1}`}}
+
+	delivery := webhook.Send(server.Client(), queued)
+	if delivery.Error != "" || delivery.Status != http.StatusOK || delivery.Attempt != 2 {
+		t.Error("delivery is incorrect", delivery)
+	}
+	if signature != "sha256="+SignWebhook("shh", body) {
+		t.Error("body wasn't signed with the secret")
+	}
+
+	var payload map[string]interface{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload["event"] != "task.created" || payload["user"] != "larz" || payload["id"] != 7.0 {
+		t.Error("payload is incorrect", payload)
+	}
+
+	status = http.StatusInternalServerError
+	delivery = webhook.Send(server.Client(), queued)
+	if delivery.Error != ErrWebhookStatus.Error() || delivery.Status != status {
+		t.Error("expected a failed delivery, got", delivery)
+	}
+
+	webhook.Secret = ""
+	webhook.Send(server.Client(), queued)
+	if signature != "" {
+		t.Error("body was signed without a secret")
+	}
+}
+
+func TestWebhookSendDevice(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ = ioutil.ReadAll(req.Body)
+	}))
+	defer server.Close()
+
+	device := &Device{Name: "phone", Token: "secret-token"}
+	data, err := json.Marshal(device.event())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &Webhook{URL: server.URL}
+	webhook.Send(server.Client(), &QueuedDelivery{User: "larz", Webhook: "abc",
+		Event: &Event{8, EventDeviceSaved, string(data)}})
+	if !strings.Contains(string(body), `"phone"`) || strings.Contains(string(body), device.Token) {
+		t.Error("delivery should have the device without its token, got", string(body))
+	}
+}
+
+func TestParseQueuedDelivery(t *testing.T) {
+	queued := &QueuedDelivery{Attempt: 2, User: "larz", Webhook: "abc",
+		Event: &Event{7, "task.created", `{"message":"a"}`}}
+
+	parsed, err := ParseQueuedDelivery(queued.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Attempt != 2 || parsed.User != "larz" || parsed.Webhook != "abc" || parsed.Event.ID != 7 ||
+		parsed.Event.Data != `{"message":"a"}` {
+		t.Error("delivery was parsed incorrectly", parsed)
+	}
+
+	_, err = ParseQueuedDelivery("2\nlarz")
+	if err != ErrWebhookDeliveryInvalid {
+		t.Error("expected an invalid delivery error, got", err)
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	webhook := &Webhook{Events: []string{"task", EventDeviceSaved}}
+
+	if !webhook.Matches("task.completed") || !webhook.Matches(EventDeviceSaved) {
+		t.Error("expected the webhook to match its events")
+	}
+	if webhook.Matches(EventActivityCreated) {
+		t.Error("expected the webhook not to match other events")
+	}
+	if !(&Webhook{}).Matches(EventActivityCreated) {
+		t.Error("expected a webhook without events to match every event")
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "10.0.0.1:80", "[::1]:443", "169.254.169.254:80"} {
+		if publicAddress("tcp", address, nil) != ErrWebhookAddressPrivate {
+			t.Error("expected", address, "to be refused")
+		}
+	}
+
+	if err := publicAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Error("expected a public address to be allowed, got", err)
+	}
+}